@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Archive moves delivered parcels created before the cutoff from parcel to
+// parcel_archive and reports how many were moved. With dryRun it only counts
+// them.
+func (s ParcelStore) Archive(before time.Time, dryRun bool) (int, error) {
+	return s.ArchiveContext(context.Background(), before, dryRun)
+}
+
+func (s ParcelStore) ArchiveContext(ctx context.Context, before time.Time, dryRun bool) (_ int, err error) {
+	defer s.observe("Archive", time.Now(), &err)
+	ctx = s.withOp(ctx, "Archive")
+
+	var moved int
+
+	cutoff := s.formatTime(before)
+
+	if dryRun {
+		query := `
+		SELECT COUNT(*)
+		FROM parcel
+		WHERE status = ? AND created_at < ? AND deleted_at IS NULL
+		`
+
+		err = s.conn().QueryRowContext(ctx, query, ParcelStatusDelivered, cutoff).Scan(&moved)
+		if err != nil {
+			return 0, err
+		}
+
+		return moved, nil
+	}
+
+	err = s.inTx(ctx, func(tx querier) error {
+		query := `
+		INSERT INTO parcel_archive (` + parcelColumns + `, archived_at)
+		SELECT ` + parcelColumns + `, ?
+		FROM parcel
+		WHERE status = ? AND created_at < ? AND deleted_at IS NULL
+		`
+
+		result, err := tx.ExecContext(ctx, query, s.now(), ParcelStatusDelivered, cutoff)
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		query = `
+		DELETE FROM parcel
+		WHERE status = ? AND created_at < ? AND deleted_at IS NULL
+		`
+
+		_, err = tx.ExecContext(ctx, query, ParcelStatusDelivered, cutoff)
+		if err != nil {
+			return err
+		}
+
+		moved = int(n)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
+
+func (s ParcelStore) GetArchived(number int) (Parcel, error) {
+	return s.GetArchivedContext(context.Background(), number)
+}
+
+func (s ParcelStore) GetArchivedContext(ctx context.Context, number int) (_ Parcel, err error) {
+	defer s.observe("GetArchived", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetArchived")
+
+	return getArchived(ctx, s.conn(), number)
+}
+
+func getArchived(ctx context.Context, q querier, number int) (Parcel, error) {
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel_archive
+	WHERE number = ?
+	`
+
+	row := q.QueryRowContext(ctx, query, number)
+
+	return scanParcel(row)
+}
+
+// Sources reported by ResolveParcel.
+const (
+	ParcelSourceLive     = "live"
+	ParcelSourceArchived = "archived"
+	ParcelSourceDeleted  = "deleted"
+)
+
+// ResolveParcel looks a parcel up among live, archived and soft-deleted
+// parcels, in that order, and reports where it was found.
+func (s ParcelStore) ResolveParcel(number int) (Parcel, string, error) {
+	return s.ResolveParcelContext(context.Background(), number)
+}
+
+func (s ParcelStore) ResolveParcelContext(ctx context.Context, number int) (p Parcel, source string, err error) {
+	defer s.observe("ResolveParcel", time.Now(), &err)
+	ctx = s.withOp(ctx, "ResolveParcel")
+
+	err = s.inTx(ctx, func(tx querier) error {
+		lookups := []struct {
+			source string
+			get    func(ctx context.Context, q querier, number int) (Parcel, error)
+		}{
+			{ParcelSourceLive, getParcel},
+			{ParcelSourceArchived, getArchived},
+			{ParcelSourceDeleted, getDeleted},
+		}
+
+		for _, lookup := range lookups {
+			var err error
+			p, err = lookup.get(ctx, tx, number)
+			if err == nil {
+				source = lookup.source
+				return nil
+			}
+			if !errors.Is(err, ErrParcelNotFound) {
+				return err
+			}
+		}
+
+		return ErrParcelNotFound
+	})
+	if err != nil {
+		return Parcel{}, "", err
+	}
+
+	return p, source, nil
+}
+
+func getDeleted(ctx context.Context, q querier, number int) (Parcel, error) {
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE number = ? AND deleted_at IS NOT NULL
+	`
+
+	row := q.QueryRowContext(ctx, query, number)
+
+	return scanParcel(row)
+}
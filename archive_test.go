@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	cutoff := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	old := cutoff.Add(-time.Hour).Format(time.RFC3339)
+	recent := cutoff.Add(time.Hour).Format(time.RFC3339)
+
+	parcels := []Parcel{
+		{Client: 1000, Status: ParcelStatusDelivered, Address: "old delivered", CreatedAt: old},
+		{Client: 1000, Status: ParcelStatusSent, Address: "old sent", CreatedAt: old},
+		{Client: 1000, Status: ParcelStatusDelivered, Address: "recent delivered", CreatedAt: recent},
+		{Client: 1000, Status: ParcelStatusDelivered, Address: "old delivered too", CreatedAt: old},
+	}
+	for i := range parcels {
+		id, err := store.Add(parcels[i])
+		require.NoError(t, err)
+		parcels[i].Number = id
+	}
+
+	moved, err := store.Archive(cutoff, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, moved)
+
+	for _, p := range parcels {
+		_, err := store.Get(p.Number)
+		require.NoError(t, err)
+	}
+
+	moved, err = store.Archive(cutoff, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, moved)
+
+	for _, i := range []int{0, 3} {
+		_, err := store.Get(parcels[i].Number)
+		require.ErrorIs(t, err, ErrParcelNotFound)
+
+		archived, err := store.GetArchived(parcels[i].Number)
+		require.NoError(t, err)
+		requireParcelEqual(t, parcels[i], archived)
+	}
+
+	for _, i := range []int{1, 2} {
+		storedParcel, err := store.Get(parcels[i].Number)
+		require.NoError(t, err)
+		requireParcelEqual(t, parcels[i], storedParcel)
+
+		_, err = store.GetArchived(parcels[i].Number)
+		require.ErrorIs(t, err, ErrParcelNotFound)
+	}
+
+	moved, err = store.Archive(cutoff, false)
+	require.NoError(t, err)
+	require.Zero(t, moved)
+}
+
+func TestResolveParcel(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	cutoff := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	archived := getTestParcel()
+	archived.Status = ParcelStatusDelivered
+	archived.CreatedAt = cutoff.Add(-time.Hour).Format(time.RFC3339)
+	archived.Number, err = store.Add(archived)
+	require.NoError(t, err)
+
+	_, err = store.Archive(cutoff, false)
+	require.NoError(t, err)
+
+	deleted := getTestParcel()
+	deleted.Number, err = store.Add(deleted)
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(deleted.Number))
+
+	live := getTestParcel()
+	live.Number, err = store.Add(live)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		parcel Parcel
+		source string
+	}{
+		{live, ParcelSourceLive},
+		{archived, ParcelSourceArchived},
+		{deleted, ParcelSourceDeleted},
+	} {
+		p, source, err := store.ResolveParcel(tt.parcel.Number)
+		require.NoError(t, err)
+		require.Equal(t, tt.source, source)
+		require.Equal(t, tt.parcel.Number, p.Number)
+	}
+
+	// A live parcel wins over an archived copy with the same number.
+	_, err = db.Exec(`
+	INSERT INTO parcel_archive (`+parcelColumns+`, archived_at)
+	SELECT `+parcelColumns+`, created_at FROM parcel WHERE number = ?`, live.Number)
+	require.NoError(t, err)
+
+	_, source, err := store.ResolveParcel(live.Number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelSourceLive, source)
+
+	_, _, err = store.ResolveParcel(live.Number + 100)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
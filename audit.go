@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Reassign moves a parcel to another client and records the old and new
+// client in the parcel's audit log.
+func (s ParcelStore) Reassign(number, newClient int) error {
+	return s.ReassignContext(context.Background(), number, newClient)
+}
+
+func (s ParcelStore) ReassignContext(ctx context.Context, number, newClient int) (err error) {
+	defer s.observe("Reassign", time.Now(), &err)
+	ctx = s.withOp(ctx, "Reassign")
+
+	if newClient <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidClient, newClient)
+	}
+
+	return s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT client
+		FROM parcel
+		WHERE number = ? AND deleted_at IS NULL
+		`
+
+		var oldClient int
+		if err := tx.QueryRowContext(ctx, query, number).Scan(&oldClient); err != nil {
+			return notFound(err)
+		}
+
+		if oldClient == newClient {
+			return nil
+		}
+
+		query = `
+		UPDATE parcel
+		SET client = ?, updated_at = ?, version = version + 1
+		WHERE number = ?
+		`
+
+		updatedAt := s.now()
+		err := checkAffected(tx.ExecContext(ctx, query, newClient, updatedAt, number))
+		if err != nil {
+			return err
+		}
+
+		return addAuditEvent(ctx, tx, number, AuditEvent{
+			Field:     "client",
+			OldValue:  strconv.Itoa(oldClient),
+			NewValue:  strconv.Itoa(newClient),
+			ChangedAt: updatedAt,
+		})
+	})
+}
+
+// GetAuditLog returns the recorded changes of a parcel, oldest first.
+func (s ParcelStore) GetAuditLog(number int) ([]AuditEvent, error) {
+	return s.GetAuditLogContext(context.Background(), number)
+}
+
+func (s ParcelStore) GetAuditLogContext(ctx context.Context, number int) (_ []AuditEvent, err error) {
+	defer s.observe("GetAuditLog", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetAuditLog")
+
+	query := `
+	SELECT field, old_value, new_value, changed_at
+	FROM parcel_audit
+	WHERE parcel_number = ?
+	ORDER BY changed_at, id
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []AuditEvent{}
+
+	for rows.Next() {
+		e := AuditEvent{}
+
+		err := rows.Scan(&e.Field, &e.OldValue, &e.NewValue, &e.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func addAuditEvent(ctx context.Context, q querier, number int, e AuditEvent) error {
+	query := `
+	INSERT INTO parcel_audit (parcel_number, field, old_value, new_value, changed_at)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := q.ExecContext(ctx, query, number, e.Field, e.OldValue, e.NewValue, e.ChangedAt)
+
+	return err
+}
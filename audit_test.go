@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReassign(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	newClient := parcel.Client + 1
+	require.NoError(t, store.Reassign(id, newClient))
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, newClient, stored.Client)
+
+	parcels, err := store.GetByClient(newClient)
+	require.NoError(t, err)
+	require.Equal(t, []int{id}, parcelNumbers(parcels))
+
+	parcels, err = store.GetByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Empty(t, parcels)
+
+	log, err := store.GetAuditLog(id)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	require.Equal(t, "client", log[0].Field)
+	require.Equal(t, "1000", log[0].OldValue)
+	require.Equal(t, "1001", log[0].NewValue)
+	require.NotEmpty(t, log[0].ChangedAt)
+
+	// Reassigning to the current client changes nothing.
+	require.NoError(t, store.Reassign(id, newClient))
+	log, err = store.GetAuditLog(id)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+}
+
+func TestReassignErrors(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	err = store.Reassign(id, 0)
+	require.ErrorIs(t, err, ErrInvalidClient)
+
+	err = store.Reassign(id+1, 1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// CachedStore wraps a Store with an LRU cache of parcels by number. Changes
+// made through it invalidate the cached parcel; changes made elsewhere are
+// not seen until the entry is evicted.
+type CachedStore struct {
+	store Store
+	size  int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[int]*list.Element
+	// fills tracks reads of uncached parcels in flight, so one that races a
+	// write doesn't cache what it read before the write.
+	fills map[int]*fill
+}
+
+type fill struct {
+	gen     int // bumped by every invalidation during the reads
+	readers int
+}
+
+var _ Store = (*CachedStore)(nil)
+
+// NewCachedStore caches up to size parcels read from store. A size below 1
+// disables caching.
+func NewCachedStore(store Store, size int) *CachedStore {
+	return &CachedStore{
+		store:   store,
+		size:    size,
+		order:   list.New(),
+		entries: map[int]*list.Element{},
+		fills:   map[int]*fill{},
+	}
+}
+
+func (c *CachedStore) Add(p Parcel) (int, error) {
+	return c.store.Add(p)
+}
+
+func (c *CachedStore) Get(number int) (Parcel, error) {
+	if p, ok := c.lookup(number); ok {
+		return p, nil
+	}
+
+	gen := c.beginFill(number)
+	p, err := c.store.Get(number)
+	c.endFill(number, gen, p, err == nil)
+
+	return p, err
+}
+
+func (c *CachedStore) GetByClient(client int) ([]Parcel, error) {
+	return c.store.GetByClient(client)
+}
+
+func (c *CachedStore) SetStatus(number int, status ParcelStatus) error {
+	defer c.invalidate(number)
+
+	return c.store.SetStatus(number, status)
+}
+
+func (c *CachedStore) SetAddress(number int, address string) error {
+	defer c.invalidate(number)
+
+	return c.store.SetAddress(number, address)
+}
+
+func (c *CachedStore) Delete(number int) error {
+	defer c.invalidate(number)
+
+	return c.store.Delete(number)
+}
+
+func (c *CachedStore) lookup(number int) (Parcel, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[number]
+	if !ok {
+		return Parcel{}, false
+	}
+	c.order.MoveToFront(e)
+
+	return e.Value.(Parcel), true
+}
+
+func (c *CachedStore) beginFill(number int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.fills[number]
+	if !ok {
+		f = &fill{}
+		c.fills[number] = f
+	}
+	f.readers++
+
+	return f.gen
+}
+
+// endFill caches p if ok and number wasn't invalidated since beginFill
+// returned gen.
+func (c *CachedStore) endFill(number, gen int, p Parcel, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := c.fills[number]
+	f.readers--
+	if f.readers == 0 {
+		delete(c.fills, number)
+	}
+
+	if ok && f.gen == gen {
+		c.put(p)
+	}
+}
+
+// put caches p. c.mu must be held.
+func (c *CachedStore) put(p Parcel) {
+	if c.size < 1 {
+		return
+	}
+
+	if e, ok := c.entries[p.Number]; ok {
+		e.Value = p
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[p.Number] = c.order.PushFront(p)
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(Parcel).Number)
+	}
+}
+
+func (c *CachedStore) invalidate(number int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f, ok := c.fills[number]; ok {
+		f.gen++
+	}
+
+	if e, ok := c.entries[number]; ok {
+		c.order.Remove(e)
+		delete(c.entries, number)
+	}
+}
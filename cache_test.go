@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the Get calls that reach the wrapped store.
+type countingStore struct {
+	Store
+	gets int
+}
+
+func (s *countingStore) Get(number int) (Parcel, error) {
+	s.gets++
+	return s.Store.Get(number)
+}
+
+func TestCachedStoreGet(t *testing.T) {
+	backend := &countingStore{Store: &fakeStore{parcels: map[int]Parcel{}}}
+	store := NewCachedStore(backend, 2)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	first, err := store.Get(id)
+	require.NoError(t, err)
+	second, err := store.Get(id)
+	require.NoError(t, err)
+
+	require.Equal(t, first, second)
+	require.Equal(t, 1, backend.gets)
+}
+
+func TestCachedStoreSetStatusInvalidates(t *testing.T) {
+	backend := &countingStore{Store: &fakeStore{parcels: map[int]Parcel{}}}
+	store := NewCachedStore(backend, 2)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	_, err = store.Get(id)
+	require.NoError(t, err)
+
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+
+	p, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, p.Status)
+	require.Equal(t, 2, backend.gets)
+}
+
+func TestCachedStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	backend := &countingStore{Store: &fakeStore{parcels: map[int]Parcel{}}}
+	store := NewCachedStore(backend, 2)
+
+	var numbers []int
+	for i := 0; i < 3; i++ {
+		id, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, id)
+	}
+
+	for _, number := range []int{numbers[0], numbers[1], numbers[0], numbers[2]} {
+		_, err := store.Get(number)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, backend.gets)
+
+	// numbers[1] was the least recently used when numbers[2] was cached.
+	_, err := store.Get(numbers[0])
+	require.NoError(t, err)
+	require.Equal(t, 3, backend.gets)
+
+	_, err = store.Get(numbers[1])
+	require.NoError(t, err)
+	require.Equal(t, 4, backend.gets)
+}
+
+// blockingStore pauses Get between reading the parcel and returning it.
+type blockingStore struct {
+	Store
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) Get(number int) (Parcel, error) {
+	p, err := s.Store.Get(number)
+	s.read <- struct{}{}
+	<-s.release
+	return p, err
+}
+
+func TestCachedStoreSkipsStaleFill(t *testing.T) {
+	backend := &blockingStore{
+		Store:   &fakeStore{parcels: map[int]Parcel{}},
+		read:    make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	store := NewCachedStore(backend, 2)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	stale := make(chan Parcel)
+	go func() {
+		p, _ := store.Get(id)
+		stale <- p
+	}()
+
+	// The status changes after the read above but before it's cached.
+	<-backend.read
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+	backend.release <- struct{}{}
+	require.Equal(t, ParcelStatusRegistered, (<-stale).Status)
+
+	go func() {
+		<-backend.read
+		backend.release <- struct{}{}
+	}()
+	p, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, p.Status)
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type dialect struct {
+	name string
+	// numberedArgs selects $1, $2, ... placeholders instead of ?.
+	numberedArgs bool
+	// returningID makes inserts read the new id via RETURNING instead of
+	// LastInsertId, which some drivers don't support.
+	returningID bool
+	schema      string
+	// indexes is run after schema. MySQL has no CREATE INDEX IF NOT EXISTS,
+	// so its schema declares the indexes inline instead.
+	indexes string
+	// classify returns the package error a driver error stands for, or nil.
+	classify func(err error) error
+}
+
+var sqliteDialect = dialect{
+	name:     "sqlite",
+	indexes:  indexes,
+	classify: classifySQLite,
+	schema: `
+	CREATE TABLE IF NOT EXISTS parcel (
+		number      INTEGER PRIMARY KEY AUTOINCREMENT,
+		client      INTEGER NOT NULL,
+		status      TEXT NOT NULL,
+		address     TEXT NOT NULL,
+		created_at  TEXT NOT NULL,
+		updated_at  TEXT NOT NULL,
+		deleted_at  TEXT,
+		weight      REAL NOT NULL DEFAULT 0,
+		recipient   TEXT NOT NULL DEFAULT '',
+		phone       TEXT NOT NULL DEFAULT '',
+		external_code TEXT UNIQUE,
+		delivered_at  TEXT,
+		price       INTEGER NOT NULL DEFAULT 0,
+		currency    TEXT NOT NULL DEFAULT '',
+		version     INTEGER NOT NULL DEFAULT 1,
+		return_reason TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_status_history (
+		id             INTEGER PRIMARY KEY AUTOINCREMENT,
+		parcel_number  INTEGER NOT NULL,
+		status         TEXT NOT NULL,
+		changed_at     TEXT NOT NULL,
+		reason         TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_audit (
+		id             INTEGER PRIMARY KEY AUTOINCREMENT,
+		parcel_number  INTEGER NOT NULL,
+		field          TEXT NOT NULL,
+		old_value      TEXT NOT NULL,
+		new_value      TEXT NOT NULL,
+		changed_at     TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_archive (
+		number       INTEGER PRIMARY KEY,
+		client       INTEGER NOT NULL,
+		status       TEXT NOT NULL,
+		address      TEXT NOT NULL,
+		created_at   TEXT NOT NULL,
+		updated_at   TEXT NOT NULL,
+		weight       REAL NOT NULL DEFAULT 0,
+		recipient    TEXT NOT NULL DEFAULT '',
+		phone        TEXT NOT NULL DEFAULT '',
+		external_code TEXT,
+		delivered_at  TEXT,
+		price        INTEGER NOT NULL DEFAULT 0,
+		currency     TEXT NOT NULL DEFAULT '',
+		version      INTEGER NOT NULL DEFAULT 1,
+		return_reason TEXT,
+		archived_at  TEXT NOT NULL
+	);`,
+}
+
+var postgresDialect = dialect{
+	name:         "postgres",
+	numberedArgs: true,
+	returningID:  true,
+	indexes:      indexes,
+	classify:     classifyPostgres,
+	schema: `
+	CREATE TABLE IF NOT EXISTS parcel (
+		number      SERIAL PRIMARY KEY,
+		client      INTEGER NOT NULL,
+		status      TEXT NOT NULL,
+		address     TEXT NOT NULL,
+		created_at  TEXT NOT NULL,
+		updated_at  TEXT NOT NULL,
+		deleted_at  TEXT,
+		weight      DOUBLE PRECISION NOT NULL DEFAULT 0,
+		recipient   TEXT NOT NULL DEFAULT '',
+		phone       TEXT NOT NULL DEFAULT '',
+		external_code TEXT UNIQUE,
+		delivered_at  TEXT,
+		price       BIGINT NOT NULL DEFAULT 0,
+		currency    TEXT NOT NULL DEFAULT '',
+		version     INTEGER NOT NULL DEFAULT 1,
+		return_reason TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_status_history (
+		id             SERIAL PRIMARY KEY,
+		parcel_number  INTEGER NOT NULL,
+		status         TEXT NOT NULL,
+		changed_at     TEXT NOT NULL,
+		reason         TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_audit (
+		id             SERIAL PRIMARY KEY,
+		parcel_number  INTEGER NOT NULL,
+		field          TEXT NOT NULL,
+		old_value      TEXT NOT NULL,
+		new_value      TEXT NOT NULL,
+		changed_at     TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_archive (
+		number       INTEGER PRIMARY KEY,
+		client       INTEGER NOT NULL,
+		status       TEXT NOT NULL,
+		address      TEXT NOT NULL,
+		created_at   TEXT NOT NULL,
+		updated_at   TEXT NOT NULL,
+		weight       DOUBLE PRECISION NOT NULL DEFAULT 0,
+		recipient    TEXT NOT NULL DEFAULT '',
+		phone        TEXT NOT NULL DEFAULT '',
+		external_code TEXT,
+		delivered_at  TEXT,
+		price        BIGINT NOT NULL DEFAULT 0,
+		currency     TEXT NOT NULL DEFAULT '',
+		version      INTEGER NOT NULL DEFAULT 1,
+		return_reason TEXT,
+		archived_at  TEXT NOT NULL
+	);`,
+}
+
+// mysqlDialect keeps timestamps in TEXT like the other dialects. Columns
+// that are indexed or have a default are VARCHAR, as MySQL allows neither
+// on TEXT.
+var mysqlDialect = dialect{
+	name:     "mysql",
+	classify: classifyMySQL,
+	schema: `
+	CREATE TABLE IF NOT EXISTS parcel (
+		number      INTEGER PRIMARY KEY AUTO_INCREMENT,
+		client      INTEGER NOT NULL,
+		status      VARCHAR(32) NOT NULL,
+		address     TEXT NOT NULL,
+		created_at  TEXT NOT NULL,
+		updated_at  TEXT NOT NULL,
+		deleted_at  TEXT,
+		weight      DOUBLE NOT NULL DEFAULT 0,
+		recipient   VARCHAR(255) NOT NULL DEFAULT '',
+		phone       VARCHAR(32) NOT NULL DEFAULT '',
+		external_code VARCHAR(255) UNIQUE,
+		delivered_at  TEXT,
+		price       BIGINT NOT NULL DEFAULT 0,
+		currency    VARCHAR(3) NOT NULL DEFAULT '',
+		version     INTEGER NOT NULL DEFAULT 1,
+		return_reason TEXT,
+		INDEX parcel_client_idx (client),
+		INDEX parcel_status_idx (status)
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_status_history (
+		id             INTEGER PRIMARY KEY AUTO_INCREMENT,
+		parcel_number  INTEGER NOT NULL,
+		status         VARCHAR(32) NOT NULL,
+		changed_at     TEXT NOT NULL,
+		reason         VARCHAR(1024) NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_audit (
+		id             INTEGER PRIMARY KEY AUTO_INCREMENT,
+		parcel_number  INTEGER NOT NULL,
+		field          TEXT NOT NULL,
+		old_value      TEXT NOT NULL,
+		new_value      TEXT NOT NULL,
+		changed_at     TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS parcel_archive (
+		number       INTEGER PRIMARY KEY,
+		client       INTEGER NOT NULL,
+		status       VARCHAR(32) NOT NULL,
+		address      TEXT NOT NULL,
+		created_at   TEXT NOT NULL,
+		updated_at   TEXT NOT NULL,
+		weight       DOUBLE NOT NULL DEFAULT 0,
+		recipient    VARCHAR(255) NOT NULL DEFAULT '',
+		phone        VARCHAR(32) NOT NULL DEFAULT '',
+		external_code VARCHAR(255),
+		delivered_at  TEXT,
+		price        BIGINT NOT NULL DEFAULT 0,
+		currency     VARCHAR(3) NOT NULL DEFAULT '',
+		version      INTEGER NOT NULL DEFAULT 1,
+		return_reason TEXT,
+		archived_at  TEXT NOT NULL
+	);`,
+}
+
+func dialectFor(driverName string) dialect {
+	switch driverName {
+	case "postgres", "pgx":
+		return postgresDialect
+	case "mysql":
+		return mysqlDialect
+	default:
+		return sqliteDialect
+	}
+}
+
+func detectDriverName(db *sql.DB) string {
+	switch fmt.Sprintf("%T", db.Driver()) {
+	case "*pq.Driver", "*stdlib.Driver":
+		return "postgres"
+	case "*mysql.MySQLDriver":
+		return "mysql"
+	default:
+		return "sqlite"
+	}
+}
+
+// isBusy reports whether err means SQLite could not get a lock in time and
+// the operation may succeed if retried.
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	// Extended result codes keep the primary code in the low byte.
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	default:
+		return false
+	}
+}
+
+func classifySQLite(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return nil
+	}
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return ErrDuplicate
+	default:
+		return nil
+	}
+}
+
+func classifyMySQL(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return nil
+	}
+
+	switch mysqlErr.Number {
+	case 1062: // ER_DUP_ENTRY
+		return ErrDuplicate
+	default:
+		return nil
+	}
+}
+
+func classifyPostgres(err error) error {
+	// lib/pq and pgx both expose the SQLSTATE this way.
+	var pgErr interface{ SQLState() string }
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+
+	switch pgErr.SQLState() {
+	case "23505": // unique_violation
+		return ErrDuplicate
+	default:
+		return nil
+	}
+}
+
+// translate wraps err with the package error it stands for, so callers can
+// check for ErrParcelNotFound, ErrDuplicate and ErrVersionConflict whatever
+// the driver. Errors that already are one of them are returned as they are.
+func (d dialect) translate(err error) error {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrParcelNotFound),
+		errors.Is(err, ErrDuplicate),
+		errors.Is(err, ErrVersionConflict):
+		return err
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w: %w", ErrParcelNotFound, err)
+	}
+
+	if d.classify != nil {
+		if typed := d.classify(err); typed != nil {
+			return fmt.Errorf("%w: %w", typed, err)
+		}
+	}
+
+	return err
+}
+
+func (d dialect) rebind(query string) string {
+	if !d.numberedArgs {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+// upsert returns the clause that turns an INSERT into an update of the listed
+// columns when the row already exists by key.
+func (d dialect) upsert(key string, columns ...string) string {
+	set := make([]string, len(columns))
+
+	if d.name == mysqlDialect.name {
+		for i, c := range columns {
+			set[i] = c + " = VALUES(" + c + ")"
+		}
+
+		return "ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+	}
+
+	for i, c := range columns {
+		set[i] = c + " = excluded." + c
+	}
+
+	return "ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// statements splits a script of ;-terminated statements, since not every
+// driver runs more than one per Exec.
+func statements(script string) []string {
+	var res []string
+
+	for _, stmt := range strings.Split(script, ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			res = append(res, stmt)
+		}
+	}
+
+	return res
+}
+
+func (d dialect) insertID(ctx context.Context, q querier, query string, args ...any) (int64, error) {
+	if d.returningID {
+		var id int64
+		err := q.QueryRowContext(ctx, query+" RETURNING number", args...).Scan(&id)
+
+		return id, err
+	}
+
+	result, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// boundQuerier rewrites queries written with ? placeholders for the default
+// table to its dialect and table.
+type boundQuerier struct {
+	q     querier
+	d     dialect
+	table string
+}
+
+func (b boundQuerier) rewrite(query string) string {
+	if b.table != "" {
+		query = renameTables(query, b.table)
+	}
+
+	return b.d.rebind(query)
+}
+
+func (b boundQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return b.q.ExecContext(ctx, b.rewrite(query), args...)
+}
+
+func (b boundQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return b.q.QueryContext(ctx, b.rewrite(query), args...)
+}
+
+func (b boundQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return b.q.QueryRowContext(ctx, b.rewrite(query), args...)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialectRebind(t *testing.T) {
+	query := "UPDATE parcel SET status = ? WHERE number = ? AND client = ?"
+
+	require.Equal(t, query, sqliteDialect.rebind(query))
+	require.Equal(t,
+		"UPDATE parcel SET status = $1 WHERE number = $2 AND client = $3",
+		postgresDialect.rebind(query))
+}
+
+func TestDialectFor(t *testing.T) {
+	require.Equal(t, sqliteDialect.name, dialectFor("sqlite").name)
+	require.Equal(t, postgresDialect.name, dialectFor("postgres").name)
+	require.Equal(t, postgresDialect.name, dialectFor("pgx").name)
+	require.Equal(t, mysqlDialect.name, dialectFor("mysql").name)
+}
+
+func TestDialectUpsert(t *testing.T) {
+	require.Equal(t,
+		"ON CONFLICT (external_code) DO UPDATE SET status = excluded.status, address = excluded.address",
+		sqliteDialect.upsert("external_code", "status", "address"))
+	require.Equal(t,
+		"ON DUPLICATE KEY UPDATE status = VALUES(status), address = VALUES(address)",
+		mysqlDialect.upsert("external_code", "status", "address"))
+}
+
+func TestStatements(t *testing.T) {
+	require.Equal(t,
+		[]string{"CREATE TABLE a (x INTEGER)", "CREATE INDEX a_idx ON a (x)"},
+		statements("\n\tCREATE TABLE a (x INTEGER);\n\tCREATE INDEX a_idx ON a (x);"))
+}
+
+func TestNewParcelStoreDetectsDialect(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	require.Equal(t, dialectFor(testDriver).name, store.dialect.name)
+
+	store = NewParcelStore(db, WithDriver("postgres"))
+	require.Equal(t, postgresDialect.name, store.dialect.name)
+
+	store = NewParcelStore(db, WithDriver("mysql"))
+	require.Equal(t, mysqlDialect.name, store.dialect.name)
+}
+
+type fakePostgresError string
+
+func (e fakePostgresError) Error() string    { return "pq: " + string(e) }
+func (e fakePostgresError) SQLState() string { return string(e) }
+
+func TestDialectTranslate(t *testing.T) {
+	driverErrors := map[string]struct {
+		duplicate, deadlock error
+	}{
+		mysqlDialect.name: {
+			duplicate: &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+			deadlock:  &mysql.MySQLError{Number: 1213, Message: "Deadlock found"},
+		},
+		postgresDialect.name: {
+			duplicate: fakePostgresError("23505"),
+			deadlock:  fakePostgresError("40001"),
+		},
+	}
+
+	for _, d := range []dialect{sqliteDialect, postgresDialect, mysqlDialect} {
+		t.Run(d.name, func(t *testing.T) {
+			require.NoError(t, d.translate(nil))
+
+			err := d.translate(fmt.Errorf("scan: %w", sql.ErrNoRows))
+			require.ErrorIs(t, err, ErrParcelNotFound)
+			require.ErrorIs(t, err, sql.ErrNoRows)
+
+			// Errors that already are the package's aren't wrapped again.
+			require.Same(t, ErrParcelNotFound, d.translate(ErrParcelNotFound))
+
+			other := errors.New("connection reset")
+			require.Same(t, other, d.translate(other))
+
+			if errs, ok := driverErrors[d.name]; ok {
+				require.ErrorIs(t, d.translate(errs.duplicate), ErrDuplicate)
+				// A deadlock says nothing about the parcel's version.
+				require.NotErrorIs(t, d.translate(errs.deadlock), ErrVersionConflict)
+			}
+		})
+	}
+
+	// Another driver's codes mean nothing to a dialect.
+	require.NotErrorIs(t, sqliteDialect.translate(driverErrors[mysqlDialect.name].duplicate), ErrDuplicate)
+}
+
+func TestStoreTranslatesErrors(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	parcel.ExternalCode = "TRANSLATE-1"
+	_, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	// The same failure surfaces as the same error from the driver in use.
+	_, err = db.Exec(store.dialect.rebind(`
+	INSERT INTO parcel (client, status, address, created_at, updated_at, external_code)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`), 1, ParcelStatusRegistered, "a", parcel.CreatedAt, parcel.CreatedAt, parcel.ExternalCode)
+	require.Error(t, err)
+	require.ErrorIs(t, store.dialect.translate(err), ErrDuplicate)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrDuplicate)
+
+	_, err = store.Get(-1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	err = store.SetAddressVersioned(id, "b", 100)
+	require.ErrorIs(t, err, ErrVersionConflict)
+}
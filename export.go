@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{"number", "client", "status", "address", "created_at"}
+
+func (s ParcelStore) ExportCSV(w io.Writer, client int) error {
+	return s.ExportCSVContext(context.Background(), w, client)
+}
+
+func (s ParcelStore) ExportCSVContext(ctx context.Context, w io.Writer, client int) (err error) {
+	defer s.observe("ExportCSV", time.Now(), &err)
+	ctx = s.withOp(ctx, "ExportCSV")
+
+	parcels, err := s.GetByClientPagedContext(ctx, client, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, p := range parcels {
+		record := []string{
+			strconv.Itoa(p.Number),
+			strconv.Itoa(p.Client),
+			string(p.Status),
+			p.Address,
+			p.CreatedAt,
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportCSV(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+
+	parcels := []Parcel{getTestParcel(), getTestParcel()}
+	parcels[0].Address = `Псков, ул. "Колотушкина", д. 5`
+	parcels[1].Address = "line one,\nline two"
+
+	for i := range parcels {
+		parcels[i].Client = client
+
+		id, err := store.Add(parcels[i])
+		require.NoError(t, err)
+		parcels[i].Number = id
+	}
+
+	_, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = store.ExportCSV(&buf, client)
+	require.NoError(t, err)
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, len(parcels)+1)
+	require.Equal(t, csvHeader, records[0])
+
+	for i, parcel := range parcels {
+		require.Equal(t, []string{
+			strconv.Itoa(parcel.Number),
+			strconv.Itoa(parcel.Client),
+			string(parcel.Status),
+			normalizeAddress(parcel.Address),
+			parcel.CreatedAt,
+		}, records[i+1])
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parcelFields lists the fields GetFields may read, by their JSON names,
+// with a scan target for each. The name is interpolated into the query as
+// the column, so it must never come from the caller unchecked.
+var parcelFields = map[string]func() any{
+	"number":        func() any { return new(int) },
+	"client":        func() any { return new(int) },
+	"status":        func() any { return new(ParcelStatus) },
+	"address":       func() any { return new(string) },
+	"address_raw":   func() any { return new(sql.NullString) },
+	"weight":        func() any { return new(float64) },
+	"recipient":     func() any { return new(string) },
+	"phone":         func() any { return new(string) },
+	"external_code": func() any { return new(sql.NullString) },
+	"price":         func() any { return new(int64) },
+	"currency":      func() any { return new(string) },
+	"created_at":    func() any { return new(string) },
+	"updated_at":    func() any { return new(string) },
+	"delivered_at":  func() any { return new(nullTimestamp) },
+	"version":       func() any { return new(int) },
+	"return_reason": func() any { return new(sql.NullString) },
+	"priority":      func() any { return new(int) },
+}
+
+// nullTimestamp scans delivered_at, which is NULL until delivery.
+type nullTimestamp struct {
+	sql.NullString
+}
+
+// GetFields reads only the given fields of a parcel, keyed by name. Values
+// have the types of the matching Parcel fields, with NULL read as the zero
+// value. Unknown names fail before anything is read.
+func (s ParcelStore) GetFields(number int, fields ...string) (map[string]any, error) {
+	return s.GetFieldsContext(context.Background(), number, fields...)
+}
+
+func (s ParcelStore) GetFieldsContext(ctx context.Context, number int, fields ...string) (_ map[string]any, err error) {
+	defer s.observe("GetFields", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetFields")
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: no fields", ErrInvalidField)
+	}
+
+	dest := make([]any, len(fields))
+	for i, field := range fields {
+		target, ok := parcelFields[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidField, field)
+		}
+		dest[i] = target()
+	}
+
+	query := `
+	SELECT ` + strings.Join(fields, ", ") + `
+	FROM parcel
+	WHERE number = ? AND deleted_at IS NULL
+	`
+
+	err = s.conn().QueryRowContext(ctx, query, number).Scan(dest...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	res := make(map[string]any, len(fields))
+	for i, field := range fields {
+		res[field], err = fieldValue(dest[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func fieldValue(target any) (any, error) {
+	switch v := target.(type) {
+	case *int:
+		return *v, nil
+	case *int64:
+		return *v, nil
+	case *float64:
+		return *v, nil
+	case *string:
+		return *v, nil
+	case *ParcelStatus:
+		return *v, nil
+	case *sql.NullString:
+		return v.String, nil
+	case *nullTimestamp:
+		if !v.Valid {
+			return (*time.Time)(nil), nil
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, v.String)
+		if err != nil {
+			return nil, err
+		}
+
+		return &t, nil
+	}
+
+	panic(fmt.Sprintf("unexpected field target %T", target))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFields(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	parcel.Price = 1250
+	parcel.Currency = "EUR"
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	fields, err := store.GetFields(id, "status", "address")
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"status":  parcel.Status,
+		"address": normalizeAddress(parcel.Address),
+	}, fields)
+
+	fields, err = store.GetFields(id, "price", "weight", "external_code", "delivered_at")
+	require.NoError(t, err)
+	require.Equal(t, parcel.Price, fields["price"])
+	require.Equal(t, parcel.Weight, fields["weight"])
+	require.Equal(t, "", fields["external_code"])
+	require.Nil(t, fields["delivered_at"])
+	require.Len(t, fields, 4)
+
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+	require.NoError(t, store.SetStatus(id, ParcelStatusDelivered))
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+
+	fields, err = store.GetFields(id, "delivered_at")
+	require.NoError(t, err)
+	require.Equal(t, stored.DeliveredAt, fields["delivered_at"])
+
+	_, err = store.GetFields(id, "status", "address; DROP TABLE parcel")
+	require.ErrorIs(t, err, ErrInvalidField)
+
+	_, err = store.GetFields(id)
+	require.ErrorIs(t, err, ErrInvalidField)
+
+	_, err = store.GetFields(id+1, "status")
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
@@ -3,11 +3,14 @@ module github.com/Yandex-Practicum/go-db-sql-final
 go 1.21
 
 require (
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/lib/pq v1.10.9
 	github.com/stretchr/testify v1.8.4
 	modernc.org/sqlite v1.27.0
 )
 
 require (
+	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/google/uuid v1.3.0 // indirect
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// HealthCheck verifies that the database is reachable and the parcel table
+// can be queried.
+func (s ParcelStore) HealthCheck(ctx context.Context) (err error) {
+	defer s.observe("HealthCheck", time.Now(), &err)
+	ctx = s.withOp(ctx, "HealthCheck")
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+
+	var one int
+	err = s.conn().QueryRowContext(ctx, "SELECT 1 FROM parcel LIMIT 1").Scan(&one)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return nil
+}
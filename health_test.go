@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheck(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+
+	store := NewParcelStore(db)
+	require.NoError(t, store.HealthCheck(context.Background()))
+
+	_, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.HealthCheck(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, store.HealthCheck(ctx), context.Canceled)
+
+	require.NoError(t, db.Close())
+	require.Error(t, store.HealthCheck(context.Background()))
+}
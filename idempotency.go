@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AddIdempotent is Add that can be retried: a key already used for the same
+// parcel returns that parcel's number without adding it again. A key used
+// for a different parcel fails with ErrIdempotencyKeyReused.
+func (s ParcelStore) AddIdempotent(key string, p Parcel) (id int, err error) {
+	return s.AddIdempotentContext(context.Background(), key, p)
+}
+
+func (s ParcelStore) AddIdempotentContext(ctx context.Context, key string, p Parcel) (id int, err error) {
+	defer s.observe("AddIdempotent", time.Now(), &err)
+	ctx = s.withOp(ctx, "AddIdempotent")
+
+	// Hashed before the store fills in CreatedAt, so that a retry without
+	// one matches.
+	hash := payloadHash(p)
+
+	err = s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT parcel_number, payload_hash
+		FROM parcel_idempotency_keys
+		WHERE idempotency_key = ?
+		`
+
+		var storedHash string
+		err := tx.QueryRowContext(ctx, query, key).Scan(&id, &storedHash)
+		switch {
+		case err == nil:
+			if storedHash != hash {
+				return fmt.Errorf("%w: %q", ErrIdempotencyKeyReused, key)
+			}
+			return nil
+		case !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+
+		id, err = s.add(ctx, tx, p)
+		if err != nil {
+			return err
+		}
+
+		query = `
+		INSERT INTO parcel_idempotency_keys (idempotency_key, payload_hash, parcel_number, created_at)
+		VALUES (?, ?, ?, ?)
+		`
+
+		_, err = tx.ExecContext(ctx, query, key, hash, id, s.now())
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// payloadHash identifies the parcel a caller asked to add.
+func payloadHash(p Parcel) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%v\x00%s\x00%s\x00%s\x00%d\x00%s\x00%s\x00%d",
+		p.Client, p.Status, p.Address, p.Weight, p.Recipient, p.Phone,
+		p.ExternalCode, p.Price, p.Currency, p.CreatedAt, p.Priority)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddIdempotent(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	parcel.CreatedAt = ""
+
+	id, err := store.AddIdempotent("request-1", parcel)
+	require.NoError(t, err)
+
+	again, err := store.AddIdempotent("request-1", parcel)
+	require.NoError(t, err)
+	require.Equal(t, id, again)
+
+	parcels, err := store.GetByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Equal(t, []int{id}, parcelNumbers(parcels))
+
+	changed := parcel
+	changed.Address = "other address"
+	_, err = store.AddIdempotent("request-1", changed)
+	require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	reprioritized := parcel
+	reprioritized.Priority = 1
+	_, err = store.AddIdempotent("request-1", reprioritized)
+	require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	other, err := store.AddIdempotent("request-2", changed)
+	require.NoError(t, err)
+	require.NotEqual(t, id, other)
+
+	// A key isn't recorded when the parcel can't be added.
+	invalid := parcel
+	invalid.Client = 0
+	_, err = store.AddIdempotent("request-3", invalid)
+	require.ErrorIs(t, err, ErrInvalidClient)
+
+	_, err = store.AddIdempotent("request-3", parcel)
+	require.NoError(t, err)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// parcelImport uses pointers for required fields so a missing field can be
+// told apart from a zero value.
+type parcelImport struct {
+	Client       *int         `json:"client"`
+	Status       ParcelStatus `json:"status"`
+	Address      *string      `json:"address"`
+	Weight       float64      `json:"weight"`
+	Recipient    string       `json:"recipient"`
+	Phone        string       `json:"phone"`
+	ExternalCode string       `json:"external_code"`
+	Price        int64        `json:"price"`
+	Currency     string       `json:"currency"`
+	Priority     int          `json:"priority"`
+	CreatedAt    string       `json:"created_at"`
+}
+
+func (s ParcelStore) ImportJSON(r io.Reader) (int, error) {
+	return s.ImportJSONContext(context.Background(), r)
+}
+
+func (s ParcelStore) ImportJSONContext(ctx context.Context, r io.Reader) (_ int, err error) {
+	defer s.observe("ImportJSON", time.Now(), &err)
+	ctx = s.withOp(ctx, "ImportJSON")
+
+	var items []parcelImport
+
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return 0, fmt.Errorf("decode parcels: %w", err)
+	}
+
+	parcels := make([]Parcel, 0, len(items))
+
+	for i, item := range items {
+		if item.Client == nil {
+			return 0, fmt.Errorf("parcel %d: missing required field %q", i, "client")
+		}
+		if item.Address == nil {
+			return 0, fmt.Errorf("parcel %d: missing required field %q", i, "address")
+		}
+
+		p := Parcel{
+			Client:       *item.Client,
+			Status:       item.Status,
+			Address:      *item.Address,
+			Weight:       item.Weight,
+			Recipient:    item.Recipient,
+			Phone:        item.Phone,
+			ExternalCode: item.ExternalCode,
+			Price:        item.Price,
+			Currency:     item.Currency,
+			Priority:     item.Priority,
+			CreatedAt:    item.CreatedAt,
+		}
+		if p.Status == "" {
+			p.Status = ParcelStatusRegistered
+		}
+
+		parcels = append(parcels, p)
+	}
+
+	ids, err := s.BulkAddContext(ctx, parcels)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportJSON(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+
+	doc := `[
+		{"client": ` + strconv.Itoa(client) + `, "address": "first", "status": "sent", "created_at": "2024-01-10T12:00:00Z", "carrier": "ignored"},
+		{"client": ` + strconv.Itoa(client) + `, "address": "second"}
+	]`
+
+	inserted, err := store.ImportJSON(strings.NewReader(doc))
+	require.NoError(t, err)
+	require.Equal(t, 2, inserted)
+
+	parcels, err := store.GetByClientPaged(client, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, parcels, 2)
+
+	require.Equal(t, "first", parcels[0].Address)
+	require.Equal(t, ParcelStatusSent, parcels[0].Status)
+	require.Equal(t, "2024-01-10T12:00:00Z", parcels[0].CreatedAt)
+
+	require.Equal(t, "second", parcels[1].Address)
+	require.Equal(t, ParcelStatusRegistered, parcels[1].Status)
+	require.NotEmpty(t, parcels[1].CreatedAt)
+}
+
+func TestImportJSONRoundTrip(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	parcel.Client = randRange.Intn(10_000_000)
+	parcel.Weight = 1.5
+	parcel.Recipient = "Ivan Petrov"
+	parcel.Phone = "+79001234567"
+	parcel.ExternalCode = "IMPORT-1"
+	parcel.Price = 12_500
+	parcel.Currency = "RUB"
+	parcel.Priority = 2
+
+	doc, err := json.Marshal([]Parcel{parcel})
+	require.NoError(t, err)
+
+	inserted, err := store.ImportJSON(bytes.NewReader(doc))
+	require.NoError(t, err)
+	require.Equal(t, 1, inserted)
+
+	parcels, err := store.GetByClientPaged(parcel.Client, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+
+	parcel.Number = parcels[0].Number
+	requireParcelEqual(t, parcel, parcels[0])
+}
+
+func TestImportJSONMalformed(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	doc := `[{"client": 1, "address": "first"}, {"client": 2, "address": `
+
+	inserted, err := store.ImportJSON(strings.NewReader(doc))
+	require.Error(t, err)
+	require.Zero(t, inserted)
+
+	parcels, err := store.GetAll(ParcelFilter{}, 0)
+	require.NoError(t, err)
+	require.Empty(t, parcels)
+}
+
+func TestImportJSONMissingField(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	doc := `[{"client": 1, "address": "first"}, {"client": 2}]`
+
+	inserted, err := store.ImportJSON(strings.NewReader(doc))
+	require.ErrorContains(t, err, "parcel 1")
+	require.ErrorContains(t, err, `"address"`)
+	require.Zero(t, inserted)
+
+	parcels, err := store.GetAll(ParcelFilter{}, 0)
+	require.NoError(t, err)
+	require.Empty(t, parcels)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MarshalJSON encodes p with created_at as it was stored, in the layout of
+// the store it came from, or null when it is empty.
+func (p Parcel) MarshalJSON() ([]byte, error) {
+	type parcel Parcel
+
+	var createdAt *string
+	if p.CreatedAt != "" {
+		createdAt = &p.CreatedAt
+	}
+
+	return json.Marshal(struct {
+		parcel
+		CreatedAt *string `json:"created_at"`
+	}{parcel(p), createdAt})
+}
+
+// UnmarshalJSON decodes a parcel whose created_at is either a string, kept as
+// it is for the store to check against its layout, or, as legacy clients send
+// it, a number of Unix seconds, which becomes RFC3339 in UTC.
+func (p *Parcel) UnmarshalJSON(data []byte) error {
+	type parcel Parcel
+
+	aux := struct {
+		*parcel
+		CreatedAt json.RawMessage `json:"created_at"`
+	}{parcel: (*parcel)(p)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	createdAt, err := decodeCreatedAt(aux.CreatedAt)
+	if err != nil {
+		return err
+	}
+	p.CreatedAt = createdAt
+
+	return nil
+}
+
+func decodeCreatedAt(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", nil
+	}
+
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", err
+		}
+
+		return s, nil
+	}
+
+	sec, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidCreatedAt, raw)
+	}
+
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339), nil
+}
+
+// MarshalJSON encodes o as its parcel with age_days added. Without it the
+// embedded Parcel's MarshalJSON would be promoted and drop age_days.
+func (o OpenParcel) MarshalJSON() ([]byte, error) {
+	data, err := o.Parcel.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	// data is a JSON object with at least the parcel's fields in it.
+	data = append(data[:len(data)-1], `,"age_days":`...)
+	data = strconv.AppendInt(data, int64(o.AgeDays), 10)
+
+	return append(data, '}'), nil
+}
+
+func (o *OpenParcel) UnmarshalJSON(data []byte) error {
+	if err := o.Parcel.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	var aux struct {
+		AgeDays int `json:"age_days"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	o.AgeDays = aux.AgeDays
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParcelJSON(t *testing.T) {
+	parcel := Parcel{
+		Number:    7,
+		Client:    1000,
+		Status:    ParcelStatusSent,
+		Address:   "test",
+		CreatedAt: "2024-02-01T09:00:00Z",
+		Version:   2,
+	}
+
+	data, err := json.Marshal(parcel)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"number": 7,
+		"client": 1000,
+		"status": "sent",
+		"address": "test",
+		"weight": 0,
+		"price": 0,
+		"created_at": "2024-02-01T09:00:00Z",
+		"version": 2
+	}`, string(data))
+
+	var decoded Parcel
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, parcel, decoded)
+
+	// Legacy clients send Unix seconds.
+	legacy := `{"number": 7, "client": 1000, "status": "sent", "address": "test", "created_at": 1706778000, "version": 2}`
+
+	decoded = Parcel{}
+	require.NoError(t, json.Unmarshal([]byte(legacy), &decoded))
+	require.Equal(t, parcel, decoded)
+}
+
+func TestOpenParcelJSON(t *testing.T) {
+	open := OpenParcel{
+		Parcel: Parcel{
+			Number:    7,
+			Client:    1000,
+			Status:    ParcelStatusSent,
+			Address:   "test",
+			CreatedAt: "2024-02-01T09:00:00Z",
+			Version:   2,
+		},
+		AgeDays: 3,
+	}
+
+	data, err := json.Marshal(open)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"number": 7,
+		"client": 1000,
+		"status": "sent",
+		"address": "test",
+		"weight": 0,
+		"price": 0,
+		"created_at": "2024-02-01T09:00:00Z",
+		"version": 2,
+		"age_days": 3
+	}`, string(data))
+
+	var decoded OpenParcel
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, open, decoded)
+}
+
+func TestParcelJSONInvalidCreatedAt(t *testing.T) {
+	var p Parcel
+	require.ErrorIs(t, json.Unmarshal([]byte(`{"created_at": 1.5}`), &p), ErrInvalidCreatedAt)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"created_at": null}`), &p))
+	require.Empty(t, p.CreatedAt)
+}
+
+func TestParcelJSONTimeFormat(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db, WithTimeFormat("2006-01-02 15:04:05", time.FixedZone("MSK", 3*60*60)))
+
+	id, err := store.Add(getTestParcelCreatedAt("2024-05-01 09:00:00"))
+	require.NoError(t, err)
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+
+	// Parcels in the store's layout round-trip.
+	data, err := json.Marshal(stored)
+	require.NoError(t, err)
+
+	var decoded Parcel
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, stored, decoded)
+
+	// Legacy Unix seconds are stored in the store's layout.
+	legacy := `{"client": 1000, "status": "registered", "address": "test", "created_at": 1714554000}`
+
+	decoded = Parcel{}
+	require.NoError(t, json.Unmarshal([]byte(legacy), &decoded))
+	id, err = store.Add(decoded)
+	require.NoError(t, err)
+
+	p, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "2024-05-01 12:00:00", p.CreatedAt)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+)
+
+// RedactedArg stands in for query arguments that may hold personal data.
+const RedactedArg = "[REDACTED]"
+
+// QueryLogger is given every statement the store runs, as sent to the
+// database. Addresses, recipient names and phones in args are replaced with
+// RedactedArg. op is the method name without the Context suffix.
+type QueryLogger interface {
+	LogQuery(op, query string, args []any)
+}
+
+// WithQueryLogger logs every statement the store runs to l.
+func WithQueryLogger(l QueryLogger) Option {
+	return func(s *ParcelStore) {
+		s.logger = l
+	}
+}
+
+type opKey struct{}
+
+// withOp names the operation running with ctx for the query logger. It
+// returns ctx as is when the store has no logger.
+func (s ParcelStore) withOp(ctx context.Context, op string) context.Context {
+	if s.logger == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, opKey{}, op)
+}
+
+// pii marks a query argument that must not be logged.
+type pii string
+
+func (p pii) Value() (driver.Value, error) {
+	return string(p), nil
+}
+
+type loggingQuerier struct {
+	q      querier
+	logger QueryLogger
+}
+
+func (l loggingQuerier) log(ctx context.Context, query string, args []any) {
+	op, _ := ctx.Value(opKey{}).(string)
+
+	logged := make([]any, len(args))
+	for i, arg := range args {
+		if _, ok := arg.(pii); ok {
+			arg = RedactedArg
+		}
+		logged[i] = arg
+	}
+
+	l.logger.LogQuery(op, query, logged)
+}
+
+func (l loggingQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	l.log(ctx, query, args)
+	return l.q.ExecContext(ctx, query, args...)
+}
+
+func (l loggingQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	l.log(ctx, query, args)
+	return l.q.QueryContext(ctx, query, args...)
+}
+
+func (l loggingQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	l.log(ctx, query, args)
+	return l.q.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type loggedQuery struct {
+	op    string
+	query string
+	args  []any
+}
+
+type fakeQueryLogger struct {
+	logged []loggedQuery
+}
+
+func (l *fakeQueryLogger) LogQuery(op, query string, args []any) {
+	l.logged = append(l.logged, loggedQuery{op, query, args})
+}
+
+func TestQueryLogger(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	logger := &fakeQueryLogger{}
+	store := NewParcelStore(db, WithQueryLogger(logger))
+
+	parcel := getTestParcel()
+	parcel.Address = "Baker Street 221b"
+	parcel.Recipient = "Sherlock Holmes"
+	parcel.Phone = "+447700900000"
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NoError(t, store.SetRecipient(id, "John Watson", "+447700900001"))
+
+	var ops []string
+	for _, l := range logger.logged {
+		ops = append(ops, l.op)
+
+		for _, arg := range l.args {
+			s, ok := arg.(string)
+			if !ok {
+				continue
+			}
+			require.NotContains(t, s, "Baker")
+			require.NotContains(t, s, "Holmes")
+			require.NotContains(t, s, "Watson")
+			require.NotContains(t, s, "+4477009")
+		}
+	}
+	require.Contains(t, ops, "Add")
+	require.Contains(t, ops, "SetRecipient")
+
+	insert := logger.logged[0]
+	require.Equal(t, "Add", insert.op)
+	require.True(t, strings.HasPrefix(strings.TrimSpace(insert.query), "INSERT INTO parcel"))
+	require.Equal(t, parcel.Client, insert.args[0])
+	for _, i := range []int{2, 3, 7, 8} { // address, address_raw, recipient, phone
+		require.Equal(t, RedactedArg, insert.args[i])
+	}
+
+	// The redacted values still reach the database.
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, parcel.Address, stored.Address)
+	require.Equal(t, "John Watson", stored.Recipient)
+}
+
+func TestNoQueryLogger(t *testing.T) {
+	store := ParcelStore{}
+	ctx := context.Background()
+
+	require.Equal(t, ctx, store.withOp(ctx, "Get"))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		store.withOp(ctx, "Get")
+	})
+	require.Zero(t, allocs)
+}
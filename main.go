@@ -1,32 +1,115 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+type ParcelStatus string
+
 const (
-	ParcelStatusRegistered = "registered"
-	ParcelStatusSent       = "sent"
-	ParcelStatusDelivered  = "delivered"
+	ParcelStatusRegistered ParcelStatus = "registered"
+	ParcelStatusSent       ParcelStatus = "sent"
+	ParcelStatusDelivered  ParcelStatus = "delivered"
+	ParcelStatusReturned   ParcelStatus = "returned"
 )
 
+// parcelStatuses lists every valid status.
+var parcelStatuses = []ParcelStatus{
+	ParcelStatusRegistered,
+	ParcelStatusSent,
+	ParcelStatusDelivered,
+	ParcelStatusReturned,
+}
+
+func (s ParcelStatus) IsValid() bool {
+	switch s {
+	case ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered, ParcelStatusReturned:
+		return true
+	default:
+		return false
+	}
+}
+
+var parcelStatusTransitions = map[ParcelStatus][]ParcelStatus{
+	ParcelStatusRegistered: {ParcelStatusSent, ParcelStatusReturned},
+	ParcelStatusSent:       {ParcelStatusDelivered, ParcelStatusReturned},
+}
+
+func canTransition(from, to ParcelStatus) bool {
+	for _, status := range parcelStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
 type Parcel struct {
-	Number    int
-	Client    int
-	Status    string
-	Address   string
-	CreatedAt string
+	Number int          `json:"number"`
+	Client int          `json:"client"`
+	Status ParcelStatus `json:"status"`
+	// Address is stored normalized; AddressRaw keeps it as it was given.
+	Address    string  `json:"address"`
+	AddressRaw string  `json:"address_raw,omitempty"`
+	Weight     float64 `json:"weight"`
+	Recipient  string  `json:"recipient,omitempty"`
+	Phone      string  `json:"phone,omitempty"`
+	// ExternalCode is an optional tracking code assigned by another system.
+	ExternalCode string `json:"external_code,omitempty"`
+	// Price is in minor units of Currency, e.g. cents.
+	Price     int64  `json:"price"`
+	Currency  string `json:"currency,omitempty"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+	// DeliveredAt is set by the store while the parcel is delivered.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// Version is incremented by the store on every change.
+	Version int `json:"version"`
+	// ReturnReason is why the parcel was returned, if it was.
+	ReturnReason string `json:"return_reason,omitempty"`
+	// Priority puts express parcels first; higher is more urgent.
+	Priority int `json:"priority,omitempty"`
+}
+
+type StatusEvent struct {
+	Status    ParcelStatus
+	ChangedAt string
+	Reason    string
+	// Actor is who made the change, or SystemActor.
+	Actor string
+}
+
+// AuditEvent records a change to a parcel field other than its status.
+type AuditEvent struct {
+	Field     string
+	OldValue  string
+	NewValue  string
+	ChangedAt string
+}
+
+// ParcelSummary is the part of a parcel shown in lists.
+type ParcelSummary struct {
+	Number int          `json:"number"`
+	Status ParcelStatus `json:"status"`
+}
+
+// OpenParcel is a parcel not yet delivered or returned, with how many whole
+// days it has been open.
+type OpenParcel struct {
+	Parcel
+	AgeDays int `json:"age_days"`
 }
 
 type ParcelService struct {
-	store ParcelStore
+	store Store
 }
 
-func NewParcelService(store ParcelStore) ParcelService {
+func NewParcelService(store Store) ParcelService {
 	return ParcelService{store: store}
 }
 
@@ -73,13 +156,13 @@ func (s ParcelService) NextStatus(number int) error {
 		return err
 	}
 
-	var nextStatus string
+	var nextStatus ParcelStatus
 	switch parcel.Status {
 	case ParcelStatusRegistered:
 		nextStatus = ParcelStatusSent
 	case ParcelStatusSent:
 		nextStatus = ParcelStatusDelivered
-	case ParcelStatusDelivered:
+	case ParcelStatusDelivered, ParcelStatusReturned:
 		return nil
 	}
 
@@ -96,34 +179,14 @@ func (s ParcelService) Delete(number int) error {
 	return s.store.Delete(number)
 }
 
-func initDB(db *sql.DB) error {
-	const createTableQuery = `
-	CREATE TABLE IF NOT EXISTS parcel (
-			number			INTEGER PRIMARY KEY AUTOINCREMENT,
-			client			INTEGR NOT NULL,
-			status			TEXT NOT NULL,
-			address			TEXT NOT NULL,
-			created_at	TEXT NOT NULL
-	);`
-
-	_, err := db.Exec(createTableQuery)
-	return err
-}
-
 func main() {
-	db, err := sql.Open("sqlite", "tracker.db")
+	store, err := OpenParcelStore("sqlite", "tracker.db")
 	if err != nil {
 		fmt.Println("Error opening db:", err)
 		return
 	}
-	defer db.Close()
-
-	if err = initDB(db); err != nil {
-		fmt.Println("Error initializing db:", err)
-		return
-	}
+	defer store.Close()
 
-	store := NewParcelStore(db)
 	service := NewParcelService(store)
 
 	client := 1
@@ -154,7 +217,9 @@ func main() {
 	}
 
 	err = service.Delete(p.Number)
-	if err != nil {
+	if errors.Is(err, ErrDeleteNotAllowed) {
+		fmt.Printf("Посылку № %d нельзя удалить: %v\n", p.Number, err)
+	} else if err != nil {
 		fmt.Println(err)
 		return
 	}
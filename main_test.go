@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStore struct {
+	parcels map[int]Parcel
+}
+
+func (s *fakeStore) Add(p Parcel) (int, error) {
+	p.Number = len(s.parcels) + 1
+	s.parcels[p.Number] = p
+	return p.Number, nil
+}
+
+func (s *fakeStore) Get(number int) (Parcel, error) {
+	return s.parcels[number], nil
+}
+
+func (s *fakeStore) GetByClient(client int) ([]Parcel, error) {
+	var res []Parcel
+	for _, p := range s.parcels {
+		if p.Client == client {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeStore) SetStatus(number int, status ParcelStatus) error {
+	p := s.parcels[number]
+	p.Status = status
+	s.parcels[number] = p
+	return nil
+}
+
+func (s *fakeStore) SetAddress(number int, address string) error {
+	p := s.parcels[number]
+	p.Address = address
+	s.parcels[number] = p
+	return nil
+}
+
+func (s *fakeStore) Delete(number int) error {
+	delete(s.parcels, number)
+	return nil
+}
+
+func TestServiceNextStatus(t *testing.T) {
+	store := &fakeStore{parcels: map[int]Parcel{}}
+	service := NewParcelService(store)
+
+	parcel, err := service.Register(1, "test")
+	require.NoError(t, err)
+
+	require.NoError(t, service.NextStatus(parcel.Number))
+	require.Equal(t, ParcelStatusSent, store.parcels[parcel.Number].Status)
+
+	require.NoError(t, service.NextStatus(parcel.Number))
+	require.Equal(t, ParcelStatusDelivered, store.parcels[parcel.Number].Status)
+
+	require.NoError(t, service.NextStatus(parcel.Number))
+	require.Equal(t, ParcelStatusDelivered, store.parcels[parcel.Number].Status)
+}
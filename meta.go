@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// SetMeta sets an extra attribute of a parcel, replacing any value the key
+// already has.
+func (s ParcelStore) SetMeta(number int, key, value string) error {
+	return s.SetMetaContext(context.Background(), number, key, value)
+}
+
+func (s ParcelStore) SetMetaContext(ctx context.Context, number int, key, value string) (err error) {
+	defer s.observe("SetMeta", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetMeta")
+
+	if key == "" {
+		return ErrInvalidMetaKey
+	}
+
+	return s.inTx(ctx, func(tx querier) error {
+		if err := parcelExists(ctx, tx, number); err != nil {
+			return err
+		}
+
+		query := `
+		INSERT INTO parcel_meta (parcel_number, meta_key, meta_value)
+		VALUES (?, ?, ?)
+		` + s.dialect.upsert("parcel_number, meta_key", "meta_value")
+
+		_, err := tx.ExecContext(ctx, query, number, key, value)
+
+		return err
+	})
+}
+
+// GetMeta returns the extra attributes of a parcel by key. A parcel without
+// any has an empty map.
+func (s ParcelStore) GetMeta(number int) (map[string]string, error) {
+	return s.GetMetaContext(context.Background(), number)
+}
+
+func (s ParcelStore) GetMetaContext(ctx context.Context, number int) (_ map[string]string, err error) {
+	defer s.observe("GetMeta", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetMeta")
+
+	meta := map[string]string{}
+
+	err = s.inTx(ctx, func(tx querier) error {
+		if err := parcelExists(ctx, tx, number); err != nil {
+			return err
+		}
+
+		query := `
+		SELECT meta_key, meta_value
+		FROM parcel_meta
+		WHERE parcel_number = ?
+		`
+
+		rows, err := tx.QueryContext(ctx, query, number)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				return err
+			}
+			meta[key] = value
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// DeleteMeta removes an extra attribute of a parcel. Deleting a key that
+// isn't set does nothing.
+func (s ParcelStore) DeleteMeta(number int, key string) error {
+	return s.DeleteMetaContext(context.Background(), number, key)
+}
+
+func (s ParcelStore) DeleteMetaContext(ctx context.Context, number int, key string) (err error) {
+	defer s.observe("DeleteMeta", time.Now(), &err)
+	ctx = s.withOp(ctx, "DeleteMeta")
+
+	query := `
+	DELETE FROM parcel_meta
+	WHERE parcel_number = ? AND meta_key = ?
+	`
+
+	return s.retry(ctx, func() error {
+		_, err := s.conn().ExecContext(ctx, query, number, key)
+
+		return err
+	})
+}
+
+// parcelExists returns ErrParcelNotFound unless the parcel exists and isn't
+// deleted.
+func parcelExists(ctx context.Context, q querier, number int) error {
+	query := `
+	SELECT number
+	FROM parcel
+	WHERE number = ? AND deleted_at IS NULL
+	`
+
+	return notFound(q.QueryRowContext(ctx, query, number).Scan(&number))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeta(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	meta, err := store.GetMeta(id)
+	require.NoError(t, err)
+	require.NotNil(t, meta)
+	require.Empty(t, meta)
+
+	require.NoError(t, store.SetMeta(id, "carrier", "dhl"))
+	require.NoError(t, store.SetMeta(id, "fragile", "true"))
+	require.NoError(t, store.SetMeta(id, "carrier", "ups"))
+
+	meta, err = store.GetMeta(id)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"carrier": "ups", "fragile": "true"}, meta)
+
+	require.NoError(t, store.DeleteMeta(id, "fragile"))
+	require.NoError(t, store.DeleteMeta(id, "fragile"))
+
+	meta, err = store.GetMeta(id)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"carrier": "ups"}, meta)
+
+	require.ErrorIs(t, store.SetMeta(id, "", "x"), ErrInvalidMetaKey)
+	require.ErrorIs(t, store.SetMeta(id+1, "carrier", "dhl"), ErrParcelNotFound)
+
+	_, err = store.GetMeta(id + 1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestMetaDeletedParcel(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewParcelStore(db, WithClock(func() time.Time { return clock }))
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetMeta(id, "route", "r-12"))
+
+	require.NoError(t, store.Delete(id))
+
+	_, err = store.GetMeta(id)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	purged, err := store.PurgeDeleted(clock.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM parcel_meta WHERE parcel_number = ?", id).Scan(&count)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const indexes = `
+	CREATE INDEX IF NOT EXISTS parcel_client_idx ON parcel (client);
+	CREATE INDEX IF NOT EXISTS parcel_status_idx ON parcel (status);`
+
+// migrationsSchema records the migrations applied to each table, so several
+// stores created WithTable can share a database.
+const migrationsSchema = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		table_name  VARCHAR(64) NOT NULL,
+		version     INTEGER NOT NULL,
+		applied_at  TEXT NOT NULL,
+		PRIMARY KEY (table_name, version)
+	)`
+
+type migration struct {
+	version int
+	// up applies the step to the tables named after table.
+	up func(tx *sql.Tx, d dialect, table string) error
+}
+
+// migrations are applied in order and never changed once released; schema
+// changes are added as new steps at the end. The first step also adopts
+// databases created before versions were tracked: its CREATE TABLE IF NOT
+// EXISTS leaves an existing parcel table as it is, so adoptBaseline adds the
+// columns such a table lacks.
+var migrations = []migration{
+	{1, func(tx *sql.Tx, d dialect, table string) error {
+		if err := execStatements(tx, d.schema, table); err != nil {
+			return err
+		}
+
+		return adoptBaseline(tx, d, table)
+	}},
+	{2, func(tx *sql.Tx, d dialect, table string) error {
+		return execStatements(tx, d.indexes, table)
+	}},
+	{3, func(tx *sql.Tx, d dialect, table string) error {
+		if d.name != sqliteDialect.name {
+			return nil
+		}
+
+		return initSearch(tx, table)
+	}},
+	{4, func(tx *sql.Tx, d dialect, table string) error {
+		return execStatements(tx, `
+			ALTER TABLE parcel ADD COLUMN address_raw TEXT;
+			ALTER TABLE parcel_archive ADD COLUMN address_raw TEXT;`, table)
+	}},
+	{5, func(tx *sql.Tx, d dialect, table string) error {
+		return execStatements(tx, `
+			ALTER TABLE parcel_status_history ADD COLUMN actor VARCHAR(255) NOT NULL DEFAULT 'system';`, table)
+	}},
+	{6, func(tx *sql.Tx, d dialect, table string) error {
+		return execStatements(tx, `
+			CREATE TABLE parcel_idempotency_keys (
+				idempotency_key  VARCHAR(255) PRIMARY KEY,
+				payload_hash     VARCHAR(64) NOT NULL,
+				parcel_number    INTEGER NOT NULL,
+				created_at       TEXT NOT NULL
+			);`, table)
+	}},
+	{7, func(tx *sql.Tx, d dialect, table string) error {
+		return execStatements(tx, `
+			CREATE TABLE parcel_meta (
+				parcel_number  INTEGER NOT NULL,
+				meta_key       VARCHAR(255) NOT NULL,
+				meta_value     TEXT NOT NULL,
+				PRIMARY KEY (parcel_number, meta_key)
+			);`, table)
+	}},
+	{8, func(tx *sql.Tx, d dialect, table string) error {
+		return execStatements(tx, `
+			ALTER TABLE parcel ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE parcel_archive ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;`, table)
+	}},
+}
+
+// baselineColumns are the columns the schema gained before migrations were
+// versioned, with how to add each to a parcel table created without them.
+var baselineColumns = []struct {
+	name, add string
+}{
+	{"updated_at", `
+		ALTER TABLE parcel ADD COLUMN updated_at VARCHAR(64) NOT NULL DEFAULT '';
+		UPDATE parcel SET updated_at = created_at;`},
+	{"deleted_at", "ALTER TABLE parcel ADD COLUMN deleted_at TEXT"},
+	{"weight", "ALTER TABLE parcel ADD COLUMN weight REAL NOT NULL DEFAULT 0"},
+	{"recipient", "ALTER TABLE parcel ADD COLUMN recipient VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"phone", "ALTER TABLE parcel ADD COLUMN phone VARCHAR(32) NOT NULL DEFAULT ''"},
+	// SQLite can't add a UNIQUE column, so the constraint is an index.
+	{"external_code", `
+		ALTER TABLE parcel ADD COLUMN external_code VARCHAR(255);
+		CREATE UNIQUE INDEX parcel_external_code_idx ON parcel (external_code);`},
+	{"delivered_at", "ALTER TABLE parcel ADD COLUMN delivered_at TEXT"},
+	{"price", "ALTER TABLE parcel ADD COLUMN price BIGINT NOT NULL DEFAULT 0"},
+	{"currency", "ALTER TABLE parcel ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT ''"},
+	{"version", "ALTER TABLE parcel ADD COLUMN version INTEGER NOT NULL DEFAULT 1"},
+	{"return_reason", "ALTER TABLE parcel ADD COLUMN return_reason TEXT"},
+}
+
+// adoptBaseline adds the missing baselineColumns to a parcel table created
+// by an early version, which CREATE TABLE IF NOT EXISTS leaves as it was.
+func adoptBaseline(tx *sql.Tx, d dialect, table string) error {
+	columns, err := tableColumns(tx, d, table)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range baselineColumns {
+		if columns[c.name] {
+			continue
+		}
+
+		if err := execStatements(tx, c.add, table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tableColumns returns the names of the columns of table.
+func tableColumns(tx *sql.Tx, d dialect, table string) (map[string]bool, error) {
+	var query string
+
+	switch d.name {
+	case postgresDialect.name:
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ?"
+	case mysqlDialect.name:
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?"
+	default:
+		query = "SELECT name FROM pragma_table_info(?)"
+	}
+
+	rows, err := tx.Query(d.rebind(query), table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = true
+	}
+
+	return columns, rows.Err()
+}
+
+func execStatements(tx *sql.Tx, script, table string) error {
+	for _, stmt := range statements(script) {
+		if _, err := tx.Exec(renameTables(stmt, table)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Migrate applies the schema migrations the database doesn't have yet. It
+// is safe to call on every start.
+func Migrate(db *sql.DB) error {
+	return MigrateTable(db, defaultTable)
+}
+
+// MigrateTable is Migrate for a store using WithTable(table).
+func MigrateTable(db *sql.DB, table string) error {
+	_, err := migrate(db, table)
+
+	return err
+}
+
+// migrate applies the missing migrations for table, each in its own
+// transaction, and returns the versions it applied.
+func migrate(db *sql.DB, table string) ([]int, error) {
+	if err := validateTableName(table); err != nil {
+		return nil, err
+	}
+
+	d := dialectFor(detectDriverName(db))
+
+	if _, err := db.Exec(migrationsSchema); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(d.rebind("SELECT version FROM schema_migrations WHERE table_name = ?"), table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		done[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var applied []int
+
+	for _, m := range migrations {
+		if done[m.version] {
+			continue
+		}
+
+		if err := applyMigration(db, d, table, m); err != nil {
+			return applied, fmt.Errorf("migration %d: %w", m.version, err)
+		}
+
+		applied = append(applied, m.version)
+	}
+
+	return applied, nil
+}
+
+func applyMigration(db *sql.DB, d dialect, table string, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx, d, table); err != nil {
+		return err
+	}
+
+	query := d.rebind("INSERT INTO schema_migrations (table_name, version, applied_at) VALUES (?, ?, ?)")
+	if _, err := tx.Exec(query, table, m.version, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
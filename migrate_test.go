@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateVersions(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, dropTestTables(db))
+
+	applied, err := migrate(db, defaultTable)
+	require.NoError(t, err)
+	require.Len(t, applied, len(migrations))
+	for i, m := range migrations {
+		require.Equal(t, m.version, applied[i])
+	}
+
+	var latest int
+	err = db.QueryRow("SELECT MAX(version) FROM schema_migrations WHERE table_name = 'parcel'").Scan(&latest)
+	require.NoError(t, err)
+	require.Equal(t, migrations[len(migrations)-1].version, latest)
+
+	_, err = NewParcelStore(db).Add(getTestParcel())
+	require.NoError(t, err)
+
+	// An up-to-date database is left alone.
+	applied, err = migrate(db, defaultTable)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	// Versions are tracked per table.
+	applied, err = migrate(db, "parcel_eu")
+	require.NoError(t, err)
+	require.Len(t, applied, len(migrations))
+
+	for _, suffix := range []string{"_fts", "_archive", "_audit", "_idempotency_keys", "_meta", "_status_history", ""} {
+		_, err := db.Exec("DROP TABLE IF EXISTS parcel_eu" + suffix)
+		require.NoError(t, err)
+	}
+}
+
+// baselineSchema is the parcel table as the first release created it, which
+// the committed tracker.db still has.
+const baselineSchema = `
+	CREATE TABLE parcel (
+		number      INTEGER PRIMARY KEY AUTOINCREMENT,
+		client      INTEGER NOT NULL,
+		status      VARCHAR(128) NOT NULL,
+		address     VARCHAR(512) NOT NULL,
+		created_at  TEXT NOT NULL
+	)`
+
+func TestMigrateBaseline(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "tracker.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(baselineSchema)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO parcel (client, status, address, created_at) VALUES (1, 'registered', 'old', '2023-01-01T00:00:00Z')")
+	require.NoError(t, err)
+
+	require.NoError(t, Migrate(db))
+
+	var live int
+	err = db.QueryRow("SELECT COUNT(*) FROM parcel WHERE deleted_at IS NULL").Scan(&live)
+	require.NoError(t, err)
+	require.Equal(t, 1, live)
+
+	store := NewParcelStore(db)
+
+	old, err := store.Get(1)
+	require.NoError(t, err)
+	require.Equal(t, "old", old.Address)
+	require.Equal(t, old.CreatedAt, old.UpdatedAt)
+	require.Equal(t, 1, old.Version)
+
+	parcel := getTestParcel()
+	parcel.ExternalCode = "BASELINE-1"
+	parcel.Number, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	stored, err := store.Get(parcel.Number)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, stored)
+
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrDuplicate)
+
+	require.NoError(t, store.SetStatus(old.Number, ParcelStatusSent))
+
+	// Nothing is left to adopt the second time.
+	applied, err := migrate(db, defaultTable)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+}
+
+func TestMigrate(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// openTestDB has already migrated once.
+	require.NoError(t, Migrate(db))
+
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name IN ('parcel_client_idx', 'parcel_status_idx')").Scan(&n)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	rows, err := db.Query("EXPLAIN QUERY PLAN SELECT "+parcelColumns+" FROM parcel WHERE client = ? AND deleted_at IS NULL", 1000)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		require.NoError(t, rows.Scan(&id, &parent, &unused, &detail))
+		plan = append(plan, detail)
+	}
+	require.NoError(t, rows.Err())
+	require.Contains(t, strings.Join(plan, "\n"), "parcel_client_idx")
+}
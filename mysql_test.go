@@ -0,0 +1,17 @@
+//go:build mysql
+
+package main
+
+import (
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Run the suite against MySQL with:
+//
+//	PARCEL_MYSQL_DSN=user:pass@tcp(host:3306)/db go test -tags mysql ./...
+func init() {
+	testDriver = "mysql"
+	testDSN = os.Getenv("PARCEL_MYSQL_DSN")
+}
@@ -0,0 +1,28 @@
+package main
+
+import "time"
+
+// Observer is told about every store operation once it finishes, for example
+// to export timings as metrics. op is the method name without the Context
+// suffix.
+type Observer interface {
+	ObserveQuery(op string, duration time.Duration, err error)
+}
+
+// WithObserver reports every operation of the store to o.
+func WithObserver(o Observer) Option {
+	return func(s *ParcelStore) {
+		s.observer = o
+	}
+}
+
+// observe is deferred by every operation. It translates the error op
+// finished with into the package's errors, see dialect.translate, and
+// reports op, started at start, to the store's observer if it has one.
+func (s ParcelStore) observe(op string, start time.Time, err *error) {
+	*err = s.dialect.translate(*err)
+
+	if s.observer != nil {
+		s.observer.ObserveQuery(op, time.Since(start), *err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type observation struct {
+	op       string
+	duration time.Duration
+	err      error
+}
+
+type fakeObserver struct {
+	observed []observation
+}
+
+func (o *fakeObserver) ObserveQuery(op string, duration time.Duration, err error) {
+	o.observed = append(o.observed, observation{op, duration, err})
+}
+
+func TestObserver(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	observer := &fakeObserver{}
+	store := NewParcelStore(db, WithObserver(observer))
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	_, err = store.Get(id + 1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	require.Len(t, observer.observed, 2)
+
+	require.Equal(t, "Add", observer.observed[0].op)
+	require.Positive(t, observer.observed[0].duration)
+	require.NoError(t, observer.observed[0].err)
+
+	require.Equal(t, "Get", observer.observed[1].op)
+	require.Positive(t, observer.observed[1].duration)
+	require.ErrorIs(t, observer.observed[1].err, ErrParcelNotFound)
+}
+
+func TestNoObserver(t *testing.T) {
+	store := ParcelStore{}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		var err error
+		store.observe("Get", time.Now(), &err)
+	})
+	require.Zero(t, allocs)
+}
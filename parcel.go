@@ -1,140 +1,2988 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
+var (
+	ErrParcelNotFound          = errors.New("parcel not found")
+	ErrInvalidStatus           = errors.New("invalid status")
+	ErrInvalidStatusTransition = errors.New("invalid status transition")
+	ErrDeleteNotAllowed        = errors.New("delete not allowed")
+	ErrInvalidWeight           = errors.New("invalid weight")
+	ErrInvalidSortField        = errors.New("invalid sort field")
+	ErrInvalidPhone            = errors.New("invalid phone")
+	ErrMissingExternalCode     = errors.New("missing external code")
+	ErrInvalidParcel           = errors.New("invalid parcel")
+	ErrInvalidClient           = errors.New("invalid client")
+	ErrEmptyAddress            = errors.New("empty address")
+	ErrInvalidCreatedAt        = errors.New("invalid created_at")
+	ErrInvalidTableName        = errors.New("invalid table name")
+	ErrInvalidCurrency         = errors.New("invalid currency")
+	ErrVersionConflict         = errors.New("version conflict")
+	ErrInvalidPage             = errors.New("invalid page")
+	ErrDuplicate               = errors.New("duplicate parcel")
+	ErrTooManyRows             = errors.New("too many rows")
+	ErrIdempotencyKeyReused    = errors.New("idempotency key reused with a different parcel")
+	ErrInvalidMetaKey          = errors.New("invalid metadata key")
+	ErrInvalidField            = errors.New("invalid field")
+)
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const parcelColumns = `number, client, status, address, created_at, updated_at, weight, recipient, phone, external_code, delivered_at, price, currency, version, return_reason, address_raw, priority`
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]+$`)
+
+// currencyPattern matches ISO 4217 alphabetic codes such as EUR.
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+func validatePhone(phone string) error {
+	if phone != "" && !phonePattern.MatchString(phone) {
+		return fmt.Errorf("%w: %q", ErrInvalidPhone, phone)
+	}
+
+	return nil
+}
+
+// timestampLayout is RFC3339 with fixed-width nanoseconds, so stored
+// timestamps order correctly when compared as strings.
+const timestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+func (s ParcelStore) now() string {
+	return s.currentTime().UTC().Format(timestampLayout)
+}
+
+func (s ParcelStore) currentTime() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+
+	return s.clock()
+}
+
+// formatTime formats t as created_at is stored.
+func (s ParcelStore) formatTime(t time.Time) string {
+	return t.In(s.timeLocation()).Format(s.timeLayout())
+}
+
+func (s ParcelStore) parseTime(value string) (time.Time, error) {
+	return time.ParseInLocation(s.timeLayout(), value, s.timeLocation())
+}
+
+func (s ParcelStore) timeLayout() string {
+	if s.layout == "" {
+		return time.RFC3339
+	}
+
+	return s.layout
+}
+
+func (s ParcelStore) timeLocation() *time.Location {
+	if s.location == nil {
+		return time.UTC
+	}
+
+	return s.location
+}
+
+// stampCreatedAt fills in a missing creation time from the store's clock and
+// rewrites a given one, in the store's layout or RFC3339, in the store's
+// format and location, so that stored times compare correctly as strings.
+func (s ParcelStore) stampCreatedAt(p Parcel) Parcel {
+	if p.CreatedAt == "" {
+		p.CreatedAt = s.formatTime(s.currentTime())
+		return p
+	}
+
+	t, err := s.parseTime(p.CreatedAt)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, p.CreatedAt)
+	}
+	if err == nil {
+		p.CreatedAt = s.formatTime(t)
+	}
+
+	return p
+}
+
+type Store interface {
+	Add(p Parcel) (int, error)
+	Get(number int) (Parcel, error)
+	GetByClient(client int) ([]Parcel, error)
+	SetStatus(number int, status ParcelStatus) error
+	SetAddress(number int, address string) error
+	Delete(number int) error
+}
+
+var _ Store = (*ParcelStore)(nil)
+
 type ParcelStore struct {
-	db *sql.DB
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect dialect
+	table   string
+	// fts is set when the parcel_fts search index exists.
+	fts bool
+	// stmts is shared by copies of the store; nil means queries run ad hoc.
+	stmts    *stmtCache
+	observer Observer
+	logger   QueryLogger
+	clock    func() time.Time
+	// layout and location are how created_at is written; see WithTimeFormat.
+	layout   string
+	location *time.Location
+
+	retries    int
+	retryDelay time.Duration
+
+	// closeDB is set when the store opened db itself, so Close closes it.
+	closeDB func() error
+	// writeMu is shared by copies of the store; see WithWriteLock.
+	writeMu *sync.Mutex
+}
+
+const (
+	defaultRetries    = 3
+	defaultRetryDelay = 10 * time.Millisecond
+	maxRetryDelay     = time.Second
+)
+
+type Option func(*ParcelStore)
+
+// WithDriver selects the SQL dialect by driver name instead of detecting it
+// from the connection.
+func WithDriver(driverName string) Option {
+	return func(s *ParcelStore) {
+		s.dialect = dialectFor(driverName)
+	}
+}
+
+// WithRetry sets how many times a write is retried while the database is
+// busy, and the delay before the first retry. The delay doubles each time,
+// up to a second.
+func WithRetry(retries int, baseDelay time.Duration) Option {
+	return func(s *ParcelStore) {
+		s.retries = retries
+		s.retryDelay = baseDelay
+	}
+}
+
+// WithMaxOpenConns limits the open connections of the store's database.
+// Limits that aren't set keep the database/sql defaults.
+func WithMaxOpenConns(n int) Option {
+	return func(s *ParcelStore) {
+		s.db.SetMaxOpenConns(n)
+	}
+}
+
+// WithMaxIdleConns sets how many idle connections the database keeps.
+func WithMaxIdleConns(n int) Option {
+	return func(s *ParcelStore) {
+		s.db.SetMaxIdleConns(n)
+	}
+}
+
+// WithConnMaxLifetime sets how long a connection may be reused. Don't use it
+// with a shared in-memory SQLite database, which is dropped once its last
+// connection closes.
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(s *ParcelStore) {
+		s.db.SetConnMaxLifetime(d)
+	}
+}
+
+// WithClock makes the store read the time from clock, which stamps updates
+// and the creation time of parcels added without one.
+func WithClock(clock func() time.Time) Option {
+	return func(s *ParcelStore) {
+		s.clock = clock
+	}
+}
+
+// WithTimeFormat makes the store write created_at with layout in loc, and
+// format the bounds of date range queries the same way. Range queries
+// compare created_at as a string, so the layout must sort chronologically;
+// CountsByDay also needs it to start with the date as YYYY-MM-DD. Given
+// times may also be RFC3339, and are rewritten. In a location with daylight
+// saving time the hour repeated when clocks go back doesn't sort, so prefer
+// a fixed zone. The default is RFC3339 in UTC.
+func WithTimeFormat(layout string, loc *time.Location) Option {
+	return func(s *ParcelStore) {
+		s.layout = layout
+		s.location = loc
+	}
+}
+
+// WithWriteLock makes the store run one write or transaction at a time.
+// SQLite allows a single writer, and on a shared-cache database concurrent
+// writers fail with SQLITE_LOCKED instead of waiting, leaving them to the
+// retries. Either way a write is visible to reads once it returns. The lock
+// only covers this store and its copies, not transactions from BeginTx.
+func WithWriteLock() Option {
+	return func(s *ParcelStore) {
+		s.writeMu = &sync.Mutex{}
+	}
+}
+
+// NewParcelStore returns a store for db configured by opts. Without options
+// it uses the parcel table and the dialect of db's driver, caches prepared
+// statements, retries busy writes 3 times, reads the system clock and writes
+// created_at as RFC3339 in UTC, without observing or logging queries. It
+// panics if the options name an invalid table.
+func NewParcelStore(db *sql.DB, opts ...Option) ParcelStore {
+	s, err := newParcelStore(db, opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func newParcelStore(db *sql.DB, opts ...Option) (ParcelStore, error) {
+	s := ParcelStore{
+		db:         db,
+		dialect:    dialectFor(detectDriverName(db)),
+		table:      defaultTable,
+		retries:    defaultRetries,
+		retryDelay: defaultRetryDelay,
+		stmts:      newStmtCache(db),
+		clock:      time.Now,
+		layout:     time.RFC3339,
+		location:   time.UTC,
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	if err := validateTableName(s.table); err != nil {
+		return ParcelStore{}, err
+	}
+
+	if s.dialect.name == sqliteDialect.name {
+		s.fts, _ = hasSearchIndex(db, s.table)
+	}
+
+	return s, nil
+}
+
+// OpenParcelStore opens and migrates the database for a store that owns it:
+// unlike a store from NewParcelStore, its Close closes the database too.
+func OpenParcelStore(driverName, dsn string, opts ...Option) (ParcelStore, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return ParcelStore{}, err
+	}
+
+	s, err := newParcelStore(db, opts...)
+	if err != nil {
+		db.Close()
+		return ParcelStore{}, err
+	}
+
+	if err := MigrateTable(db, s.table); err != nil {
+		db.Close()
+		return ParcelStore{}, err
+	}
+
+	if s.dialect.name == sqliteDialect.name {
+		s.fts, _ = hasSearchIndex(db, s.table)
+	}
+
+	s.closeDB = sync.OnceValue(db.Close)
+
+	return s, nil
+}
+
+// OpenFileStore opens the SQLite database file at path, creating it if
+// missing, in WAL mode with a busy timeout, and migrates it. Close the store
+// to close the database.
+func OpenFileStore(path string, opts ...Option) (ParcelStore, error) {
+	dsn := "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+
+	return OpenParcelStore("sqlite", dsn, opts...)
+}
+
+func (s ParcelStore) Add(p Parcel) (int, error) {
+	return s.AddContext(context.Background(), p)
+}
+
+func (s ParcelStore) AddContext(ctx context.Context, p Parcel) (_ int, err error) {
+	defer s.observe("Add", time.Now(), &err)
+	ctx = s.withOp(ctx, "Add")
+
+	var id int
+
+	err = s.inTx(ctx, func(tx querier) error {
+		var err error
+		id, err = s.add(ctx, tx, p)
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// AddIfNotExists adds p unless the client already has a parcel to the same
+// address created on the same day in the store's location. existed reports
+// that the id is of that earlier parcel.
+func (s ParcelStore) AddIfNotExists(p Parcel) (id int, existed bool, err error) {
+	return s.AddIfNotExistsContext(context.Background(), p)
+}
+
+func (s ParcelStore) AddIfNotExistsContext(ctx context.Context, p Parcel) (id int, existed bool, err error) {
+	defer s.observe("AddIfNotExists", time.Now(), &err)
+	ctx = s.withOp(ctx, "AddIfNotExists")
+
+	p = s.stampCreatedAt(p)
+	if err := p.validate(s.timeLayout()); err != nil {
+		return 0, false, err
+	}
+
+	createdAt, err := s.parseTime(p.CreatedAt)
+	if err != nil {
+		return 0, false, err
+	}
+
+	createdAt = createdAt.In(s.timeLocation())
+	dayStart := time.Date(createdAt.Year(), createdAt.Month(), createdAt.Day(), 0, 0, 0, 0, createdAt.Location())
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	err = s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT number
+		FROM parcel
+		WHERE client = ? AND address = ? AND created_at >= ? AND created_at < ? AND deleted_at IS NULL
+		ORDER BY number
+		LIMIT 1
+		`
+
+		err := tx.QueryRowContext(ctx, query,
+			p.Client,
+			pii(normalizeAddress(p.Address)),
+			s.formatTime(dayStart),
+			s.formatTime(dayEnd),
+		).Scan(&id)
+		if err == nil {
+			existed = true
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		existed = false
+		id, err = s.add(ctx, tx, p)
+
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, existed, nil
+}
+
+// AddFull adds p and returns it as stored, with its number and the fields
+// filled in by the store.
+func (s ParcelStore) AddFull(p Parcel) (Parcel, error) {
+	return s.AddFullContext(context.Background(), p)
+}
+
+func (s ParcelStore) AddFullContext(ctx context.Context, p Parcel) (_ Parcel, err error) {
+	defer s.observe("AddFull", time.Now(), &err)
+	ctx = s.withOp(ctx, "AddFull")
+
+	var stored Parcel
+
+	err = s.inTx(ctx, func(tx querier) error {
+		id, err := s.add(ctx, tx, p)
+		if err != nil {
+			return err
+		}
+
+		stored, err = getParcel(ctx, tx, id)
+
+		return err
+	})
+	if err != nil {
+		return Parcel{}, err
+	}
+
+	return stored, nil
+}
+
+// Duplicate registers a copy of a parcel as a new one, created now, and
+// returns its number. The external code isn't copied, as it must be unique.
+func (s ParcelStore) Duplicate(number int) (newID int, err error) {
+	return s.DuplicateContext(context.Background(), number)
+}
+
+func (s ParcelStore) DuplicateContext(ctx context.Context, number int) (newID int, err error) {
+	defer s.observe("Duplicate", time.Now(), &err)
+	ctx = s.withOp(ctx, "Duplicate")
+
+	err = s.inTx(ctx, func(tx querier) error {
+		p, err := getParcel(ctx, tx, number)
+		if err != nil {
+			return err
+		}
+
+		p.Address = p.AddressRaw
+		p.Status = ParcelStatusRegistered
+		p.CreatedAt = ""
+		p.ExternalCode = ""
+		p.DeliveredAt = nil
+		p.ReturnReason = ""
+
+		newID, err = s.add(ctx, tx, p)
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (s ParcelStore) BulkAdd(parcels []Parcel) ([]int, error) {
+	return s.BulkAddContext(context.Background(), parcels)
+}
+
+func (s ParcelStore) BulkAddContext(ctx context.Context, parcels []Parcel) (_ []int, err error) {
+	defer s.observe("BulkAdd", time.Now(), &err)
+	ctx = s.withOp(ctx, "BulkAdd")
+
+	ids := make([]int, 0, len(parcels))
+
+	err = s.inTx(ctx, func(tx querier) error {
+		ids = ids[:0]
+
+		for _, p := range parcels {
+			id, err := s.add(ctx, tx, p)
+			if err != nil {
+				return err
+			}
+
+			ids = append(ids, id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Validate reports every problem with p that would make it a bad row. The
+// error wraps ErrInvalidParcel and the error for each failed check.
+func (p Parcel) Validate() error {
+	return p.validate(time.RFC3339)
+}
+
+// validate is Validate for a created_at written with layout.
+func (p Parcel) validate(layout string) error {
+	var problems []error
+
+	if p.Client <= 0 {
+		problems = append(problems, fmt.Errorf("%w: %d", ErrInvalidClient, p.Client))
+	}
+	if strings.TrimSpace(p.Address) == "" {
+		problems = append(problems, ErrEmptyAddress)
+	}
+	if !p.Status.IsValid() {
+		problems = append(problems, fmt.Errorf("%w: %q", ErrInvalidStatus, p.Status))
+	}
+	if _, err := time.Parse(layout, p.CreatedAt); err != nil {
+		problems = append(problems, fmt.Errorf("%w: %q", ErrInvalidCreatedAt, p.CreatedAt))
+	}
+	if p.Weight < 0 {
+		problems = append(problems, fmt.Errorf("%w: %v", ErrInvalidWeight, p.Weight))
+	}
+	if err := validatePhone(p.Phone); err != nil {
+		problems = append(problems, err)
+	}
+	if p.Price != 0 && !currencyPattern.MatchString(p.Currency) {
+		problems = append(problems, fmt.Errorf("%w: %q", ErrInvalidCurrency, p.Currency))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", ErrInvalidParcel, errors.Join(problems...))
+}
+
+// nullString stores empty strings as NULL, so optional unique columns don't
+// collide on the empty value.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// normalizeAddress trims address and collapses runs of whitespace inside it
+// to single spaces.
+func normalizeAddress(address string) string {
+	return strings.Join(strings.Fields(address), " ")
+}
+
+// deliveredAt returns the delivery time to store when a parcel whose delivery
+// time is current moves to status: kept while it stays delivered, set on
+// delivery and cleared otherwise.
+func deliveredAt(status ParcelStatus, current sql.NullString, now string) sql.NullString {
+	if status != ParcelStatusDelivered {
+		return sql.NullString{}
+	}
+	if current.Valid {
+		return current
+	}
+
+	return nullString(now)
+}
+
+func (s ParcelStore) add(ctx context.Context, q querier, p Parcel) (int, error) {
+	p = s.stampCreatedAt(p)
+	if err := p.validate(s.timeLayout()); err != nil {
+		return 0, err
+	}
+
+	query := `
+	INSERT INTO parcel (client, status, address, address_raw, created_at, updated_at, weight, recipient, phone, external_code, delivered_at, price, currency, priority)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	updatedAt := s.now()
+
+	id, err := s.dialect.insertID(ctx, q, query,
+		p.Client,
+		p.Status,
+		pii(normalizeAddress(p.Address)),
+		pii(p.Address),
+		p.CreatedAt,
+		updatedAt,
+		p.Weight,
+		pii(p.Recipient),
+		pii(p.Phone),
+		nullString(p.ExternalCode),
+		deliveredAt(p.Status, sql.NullString{}, updatedAt),
+		p.Price,
+		p.Currency,
+		p.Priority,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	err = addStatusEvent(ctx, q, int(id), StatusEvent{Status: p.Status, ChangedAt: updatedAt})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Upsert inserts p, or updates the parcel that already has its external
+// code. created reports whether a new parcel was inserted. The creation time
+// of an existing parcel is kept, as is its address unless it is registered,
+// and its status may only change as SetStatus allows. A code that belongs to
+// a deleted parcel is reported as ErrDuplicate.
+func (s ParcelStore) Upsert(p Parcel) (id int, created bool, err error) {
+	return s.UpsertContext(context.Background(), p)
+}
+
+func (s ParcelStore) UpsertContext(ctx context.Context, p Parcel) (id int, created bool, err error) {
+	defer s.observe("Upsert", time.Now(), &err)
+	ctx = s.withOp(ctx, "Upsert")
+
+	if p.ExternalCode == "" {
+		return 0, false, ErrMissingExternalCode
+	}
+	p = s.stampCreatedAt(p)
+	if err := p.validate(s.timeLayout()); err != nil {
+		return 0, false, err
+	}
+
+	err = s.inTx(ctx, func(tx querier) error {
+		var prev ParcelStatus
+		var prevAddress string
+		var prevAddressRaw, prevDeliveredAt, prevDeletedAt sql.NullString
+
+		created = false
+		query := `
+		SELECT number, status, address, address_raw, delivered_at, deleted_at
+		FROM parcel
+		WHERE external_code = ?
+		`
+		err := tx.QueryRowContext(ctx, query, p.ExternalCode).
+			Scan(&id, &prev, &prevAddress, &prevAddressRaw, &prevDeliveredAt, &prevDeletedAt)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			created = true
+		case err != nil:
+			return err
+		case prevDeletedAt.Valid:
+			return fmt.Errorf("%w: external code %q belongs to deleted parcel %d", ErrDuplicate, p.ExternalCode, id)
+		case prev != p.Status && !canTransition(prev, p.Status):
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, prev, p.Status)
+		}
+
+		address, addressRaw := normalizeAddress(p.Address), p.Address
+		if !created && prev != ParcelStatusRegistered {
+			address, addressRaw = prevAddress, prevAddressRaw.String
+			if !prevAddressRaw.Valid {
+				addressRaw = prevAddress
+			}
+		}
+
+		query = `
+		INSERT INTO parcel (client, status, address, address_raw, created_at, updated_at, weight, recipient, phone, external_code, delivered_at, price, currency, priority)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		` + s.dialect.upsert("external_code",
+			"client", "status", "address", "address_raw", "updated_at", "weight",
+			"recipient", "phone", "delivered_at", "price", "currency", "priority") + `,
+			version = parcel.version + 1
+		`
+
+		updatedAt := s.now()
+
+		n, err := s.dialect.insertID(ctx, tx, query,
+			p.Client,
+			p.Status,
+			pii(address),
+			pii(addressRaw),
+			p.CreatedAt,
+			updatedAt,
+			p.Weight,
+			pii(p.Recipient),
+			pii(p.Phone),
+			p.ExternalCode,
+			deliveredAt(p.Status, prevDeliveredAt, updatedAt),
+			p.Price,
+			p.Currency,
+			p.Priority,
+		)
+		if err != nil {
+			return err
+		}
+
+		// LastInsertId is only meaningful when a row was inserted.
+		if created {
+			id = int(n)
+		}
+
+		if created || prev != p.Status {
+			return addStatusEvent(ctx, tx, id, StatusEvent{Status: p.Status, ChangedAt: updatedAt})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, created, nil
+}
+
+func (s ParcelStore) Get(number int) (Parcel, error) {
+	return s.GetContext(context.Background(), number)
+}
+
+func (s ParcelStore) GetContext(ctx context.Context, number int) (_ Parcel, err error) {
+	defer s.observe("Get", time.Now(), &err)
+	ctx = s.withOp(ctx, "Get")
+
+	return getParcel(ctx, s.conn(), number)
+}
+
+func getParcel(ctx context.Context, q querier, number int) (Parcel, error) {
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE number = ? AND deleted_at IS NULL
+	`
+
+	row := q.QueryRowContext(ctx, query, number)
+
+	return scanParcel(row)
+}
+
+// GetByNumbers returns the parcels with the given numbers, keyed by number.
+// Numbers without a parcel are left out of the map.
+func (s ParcelStore) GetByNumbers(numbers []int) (map[int]Parcel, error) {
+	return s.GetByNumbersContext(context.Background(), numbers)
+}
+
+func (s ParcelStore) GetByNumbersContext(ctx context.Context, numbers []int) (_ map[int]Parcel, err error) {
+	defer s.observe("GetByNumbers", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByNumbers")
+
+	res := make(map[int]Parcel, len(numbers))
+	if len(numbers) == 0 {
+		return res, nil
+	}
+
+	args := make([]any, len(numbers))
+	for i, n := range numbers {
+		args[i] = n
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE number IN (` + placeholders(len(numbers)) + `) AND deleted_at IS NULL
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	parcels, err := scanParcels(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range parcels {
+		res[p.Number] = p
+	}
+
+	return res, nil
+}
+
+// placeholders returns n comma-separated ? placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// Exists reports whether a parcel with the number exists, without reading
+// the row.
+func (s ParcelStore) Exists(number int) (bool, error) {
+	return s.ExistsContext(context.Background(), number)
+}
+
+func (s ParcelStore) ExistsContext(ctx context.Context, number int) (_ bool, err error) {
+	defer s.observe("Exists", time.Now(), &err)
+	ctx = s.withOp(ctx, "Exists")
+
+	query := `
+	SELECT EXISTS (SELECT 1 FROM parcel WHERE number = ? AND deleted_at IS NULL)
+	`
+
+	var exists bool
+	err = s.conn().QueryRowContext(ctx, query, number).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (s ParcelStore) GetIncludingDeleted(number int) (Parcel, error) {
+	return s.GetIncludingDeletedContext(context.Background(), number)
+}
+
+func (s ParcelStore) GetIncludingDeletedContext(ctx context.Context, number int) (_ Parcel, err error) {
+	defer s.observe("GetIncludingDeleted", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetIncludingDeleted")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE number = ?
+	`
+
+	row := s.conn().QueryRowContext(ctx, query, number)
+
+	return scanParcel(row)
+}
+
+// GetLatestByClient returns the client's most recently created parcel.
+func (s ParcelStore) GetLatestByClient(client int) (Parcel, error) {
+	return s.GetLatestByClientContext(context.Background(), client)
+}
+
+func (s ParcelStore) GetLatestByClientContext(ctx context.Context, client int) (_ Parcel, err error) {
+	defer s.observe("GetLatestByClient", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetLatestByClient")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	ORDER BY created_at DESC, number DESC
+	LIMIT 1
+	`
+
+	row := s.conn().QueryRowContext(ctx, query, client)
+
+	return scanParcel(row)
+}
+
+// GetOldestRegistered returns the registered parcel that has waited longest,
+// or ErrParcelNotFound when none are registered.
+func (s ParcelStore) GetOldestRegistered() (Parcel, error) {
+	return s.GetOldestRegisteredContext(context.Background())
+}
+
+func (s ParcelStore) GetOldestRegisteredContext(ctx context.Context) (_ Parcel, err error) {
+	defer s.observe("GetOldestRegistered", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetOldestRegistered")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE status = ? AND deleted_at IS NULL
+	ORDER BY created_at, number
+	LIMIT 1
+	`
+
+	row := s.conn().QueryRowContext(ctx, query, ParcelStatusRegistered)
+
+	return scanParcel(row)
+}
+
+func (s ParcelStore) GetByClient(client int) ([]Parcel, error) {
+	return s.GetByClientContext(context.Background(), client)
+}
+
+func (s ParcelStore) GetByClientContext(ctx context.Context, client int) (_ []Parcel, err error) {
+	defer s.observe("GetByClient", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClient")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// GetByClientInto is GetByClient reading into *dst, which is truncated
+// first and reuses its capacity, so a caller can pool the slice.
+func (s ParcelStore) GetByClientInto(client int, dst *[]Parcel) error {
+	return s.GetByClientIntoContext(context.Background(), client, dst)
+}
+
+func (s ParcelStore) GetByClientIntoContext(ctx context.Context, client int, dst *[]Parcel) (err error) {
+	defer s.observe("GetByClientInto", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientInto")
+
+	*dst = (*dst)[:0]
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client)
+	if err != nil {
+		return err
+	}
+
+	return eachParcel(rows, func(p Parcel) error {
+		*dst = append(*dst, p)
+		return nil
+	})
+}
+
+// GetOpenByClient returns the client's registered and sent parcels, oldest
+// first, each with its age in whole days by the store's clock.
+func (s ParcelStore) GetOpenByClient(client int) ([]OpenParcel, error) {
+	return s.GetOpenByClientContext(context.Background(), client)
+}
+
+func (s ParcelStore) GetOpenByClientContext(ctx context.Context, client int) (_ []OpenParcel, err error) {
+	defer s.observe("GetOpenByClient", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetOpenByClient")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND status IN (?, ?) AND deleted_at IS NULL
+	ORDER BY created_at, number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client, ParcelStatusRegistered, ParcelStatusSent)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.currentTime()
+	res := []OpenParcel{}
+
+	err = eachParcel(rows, func(p Parcel) error {
+		createdAt, err := s.parseTime(p.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		res = append(res, OpenParcel{Parcel: p, AgeDays: int(now.Sub(createdAt) / (24 * time.Hour))})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetByClientGrouped returns the client's parcels grouped by status, each
+// group oldest first. Statuses without parcels are left out.
+func (s ParcelStore) GetByClientGrouped(client int) (map[ParcelStatus][]Parcel, error) {
+	return s.GetByClientGroupedContext(context.Background(), client)
+}
+
+func (s ParcelStore) GetByClientGroupedContext(ctx context.Context, client int) (_ map[ParcelStatus][]Parcel, err error) {
+	defer s.observe("GetByClientGrouped", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientGrouped")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	ORDER BY created_at, number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[ParcelStatus][]Parcel{}
+
+	err = eachParcel(rows, func(p Parcel) error {
+		res[p.Status] = append(res[p.Status], p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetByClients returns the parcels of each client, numbered in ascending
+// order, read with a single query. Every client asked for is in the map,
+// with an empty slice if it has no parcels.
+func (s ParcelStore) GetByClients(clients []int) (map[int][]Parcel, error) {
+	return s.GetByClientsContext(context.Background(), clients)
+}
+
+func (s ParcelStore) GetByClientsContext(ctx context.Context, clients []int) (_ map[int][]Parcel, err error) {
+	defer s.observe("GetByClients", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClients")
+
+	res := make(map[int][]Parcel, len(clients))
+	if len(clients) == 0 {
+		return res, nil
+	}
+
+	args := make([]any, len(clients))
+	for i, c := range clients {
+		args[i] = c
+		res[c] = []Parcel{}
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client IN (` + placeholders(len(clients)) + `) AND deleted_at IS NULL
+	ORDER BY number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = eachParcel(rows, func(p Parcel) error {
+		res[p.Client] = append(res[p.Client], p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetByClientsDeduped returns the parcels of all the clients as one list
+// sorted by number. Clients given more than once are read once.
+func (s ParcelStore) GetByClientsDeduped(clients []int) ([]Parcel, error) {
+	return s.GetByClientsDedupedContext(context.Background(), clients)
+}
+
+func (s ParcelStore) GetByClientsDedupedContext(ctx context.Context, clients []int) (_ []Parcel, err error) {
+	defer s.observe("GetByClientsDeduped", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientsDeduped")
+
+	if len(clients) == 0 {
+		return []Parcel{}, nil
+	}
+
+	seen := make(map[int]bool, len(clients))
+	args := make([]any, 0, len(clients))
+	for _, c := range clients {
+		if !seen[c] {
+			seen[c] = true
+			args = append(args, c)
+		}
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client IN (` + placeholders(len(args)) + `) AND deleted_at IS NULL
+	ORDER BY number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+const clientSummariesQuery = `
+	SELECT number, status
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	ORDER BY number
+	`
+
+// GetClientSummaries returns the number and status of each of the client's
+// parcels, without reading the other columns.
+func (s ParcelStore) GetClientSummaries(client int) ([]ParcelSummary, error) {
+	return s.GetClientSummariesContext(context.Background(), client)
+}
+
+func (s ParcelStore) GetClientSummariesContext(ctx context.Context, client int) (_ []ParcelSummary, err error) {
+	defer s.observe("GetClientSummaries", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetClientSummaries")
+
+	rows, err := s.conn().QueryContext(ctx, clientSummariesQuery, client)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []ParcelSummary{}
+
+	for rows.Next() {
+		var summary ParcelSummary
+
+		if err := rows.Scan(&summary.Number, &summary.Status); err != nil {
+			return nil, err
+		}
+
+		res = append(res, summary)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetByClientFunc calls fn for each of the client's parcels as rows are read,
+// without loading them all into memory. It stops at the first error from fn,
+// or once ctx is done, and returns it.
+func (s ParcelStore) GetByClientFunc(client int, fn func(Parcel) error) error {
+	return s.GetByClientFuncContext(context.Background(), client, fn)
+}
+
+func (s ParcelStore) GetByClientFuncContext(ctx context.Context, client int, fn func(Parcel) error) (err error) {
+	defer s.observe("GetByClientFunc", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientFunc")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	ORDER BY number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client)
+	if err != nil {
+		return err
+	}
+
+	return eachParcel(rows, func(p Parcel) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return fn(p)
+	})
+}
+
+func (s ParcelStore) GetByClientPaged(client, limit, offset int) ([]Parcel, error) {
+	return s.GetByClientPagedContext(context.Background(), client, limit, offset)
+}
+
+func (s ParcelStore) GetByClientPagedContext(ctx context.Context, client, limit, offset int) (_ []Parcel, err error) {
+	defer s.observe("GetByClientPaged", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientPaged")
+
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	ORDER BY number
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// ParcelPage is one page of results and the total number of results across
+// all pages. Page numbers start at 1.
+type ParcelPage struct {
+	Items    []Parcel
+	Total    int
+	Page     int
+	PageSize int
+}
+
+// GetByClientPage returns the given page of the client's parcels, ordered by
+// number. The total is counted in the same transaction as the page is read.
+func (s ParcelStore) GetByClientPage(client, page, size int) (ParcelPage, error) {
+	return s.GetByClientPageContext(context.Background(), client, page, size)
+}
+
+func (s ParcelStore) GetByClientPageContext(ctx context.Context, client, page, size int) (_ ParcelPage, err error) {
+	defer s.observe("GetByClientPage", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientPage")
+
+	if page < 1 || size < 1 {
+		return ParcelPage{}, fmt.Errorf("%w: page %d of size %d", ErrInvalidPage, page, size)
+	}
+
+	res := ParcelPage{Page: page, PageSize: size}
+
+	err = s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT COUNT(*)
+		FROM parcel
+		WHERE client = ? AND deleted_at IS NULL
+		`
+
+		err := tx.QueryRowContext(ctx, query, client).Scan(&res.Total)
+		if err != nil {
+			return err
+		}
+
+		query = `
+		SELECT ` + parcelColumns + `
+		FROM parcel
+		WHERE client = ? AND deleted_at IS NULL
+		ORDER BY number
+		LIMIT ? OFFSET ?
+		`
+
+		rows, err := tx.QueryContext(ctx, query, client, size, (page-1)*size)
+		if err != nil {
+			return err
+		}
+
+		res.Items, err = scanParcels(rows)
+
+		return err
+	})
+	if err != nil {
+		return ParcelPage{}, err
+	}
+
+	return res, nil
+}
+
+type ParcelFilter struct {
+	Client        int
+	Status        ParcelStatus
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// DefaultGetAllLimit caps GetAll when it is called with a zero limit.
+const DefaultGetAllLimit = 10_000
+
+// GetAll returns the parcels matching filter. If more than limit match it
+// returns ErrTooManyRows and no parcels; a zero limit means
+// DefaultGetAllLimit.
+func (s ParcelStore) GetAll(filter ParcelFilter, limit int) ([]Parcel, error) {
+	return s.GetAllContext(context.Background(), filter, limit)
+}
+
+func (s ParcelStore) GetAllContext(ctx context.Context, filter ParcelFilter, limit int) (_ []Parcel, err error) {
+	defer s.observe("GetAll", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetAll")
+
+	if limit == 0 {
+		limit = DefaultGetAllLimit
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit %d", ErrInvalidPage, limit)
+	}
+
+	where := []string{"deleted_at IS NULL"}
+	var args []any
+
+	if filter.Client != 0 {
+		where = append(where, "client = ?")
+		args = append(args, filter.Client)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		where = append(where, "created_at > ?")
+		args = append(args, s.formatTime(filter.CreatedAfter))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, s.formatTime(filter.CreatedBefore))
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY number
+	LIMIT ?
+	`
+
+	// One row past the limit is enough to tell that it was exceeded.
+	rows, err := s.conn().QueryContext(ctx, query, append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := scanParcels(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) > limit {
+		return nil, fmt.Errorf("%w: more than %d parcels match", ErrTooManyRows, limit)
+	}
+
+	return res, nil
+}
+
+// GetByAddressLike finds parcels whose address contains pattern, ignoring
+// case. Both sides are lowercased by the database, and SQLite only folds
+// ASCII letters, so other letters there must match in case. Wildcards in
+// pattern are matched literally.
+func (s ParcelStore) GetByAddressLike(pattern string) ([]Parcel, error) {
+	return s.GetByAddressLikeContext(context.Background(), pattern)
+}
+
+func (s ParcelStore) GetByAddressLikeContext(ctx context.Context, pattern string) (_ []Parcel, err error) {
+	defer s.observe("GetByAddressLike", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByAddressLike")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE LOWER(address) LIKE LOWER(?) ESCAPE '!' AND deleted_at IS NULL
+	ORDER BY number
+	`
+
+	like := "%" + escapeLike(pattern) + "%"
+
+	rows, err := s.conn().QueryContext(ctx, query, pii(like))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// likeEscaper escapes with ! rather than \, which MySQL would read as an
+// escape inside the ESCAPE string literal itself.
+var likeEscaper = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// GetByDateRange returns parcels created between from and to inclusive. A
+// zero to means up to now.
+func (s ParcelStore) GetByDateRange(from, to time.Time) ([]Parcel, error) {
+	return s.GetByDateRangeContext(context.Background(), from, to)
+}
+
+func (s ParcelStore) GetByDateRangeContext(ctx context.Context, from, to time.Time) (_ []Parcel, err error) {
+	defer s.observe("GetByDateRange", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByDateRange")
+
+	if to.IsZero() {
+		to = s.currentTime()
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE created_at >= ? AND created_at <= ? AND deleted_at IS NULL
+	ORDER BY created_at, number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query,
+		s.formatTime(from),
+		s.formatTime(to),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// CountCreatedToday counts parcels created during the current day in loc,
+// or in UTC when loc is nil.
+func (s ParcelStore) CountCreatedToday(loc *time.Location) (int, error) {
+	return s.CountCreatedTodayContext(context.Background(), loc)
+}
+
+func (s ParcelStore) CountCreatedTodayContext(ctx context.Context, loc *time.Location) (_ int, err error) {
+	defer s.observe("CountCreatedToday", time.Now(), &err)
+	ctx = s.withOp(ctx, "CountCreatedToday")
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	now := s.currentTime().In(loc)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	query := `
+	SELECT COUNT(*)
+	FROM parcel
+	WHERE created_at >= ? AND created_at < ? AND deleted_at IS NULL
+	`
+
+	var count int
+	err = s.conn().QueryRowContext(ctx, query,
+		s.formatTime(dayStart),
+		s.formatTime(dayEnd),
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CountsByDay counts parcels by the UTC date they were created, for the days
+// from from to to inclusive, keyed by YYYY-MM-DD. Days without parcels are
+// left out.
+func (s ParcelStore) CountsByDay(from, to time.Time) (map[string]int, error) {
+	return s.CountsByDayContext(context.Background(), from, to)
+}
+
+func (s ParcelStore) CountsByDayContext(ctx context.Context, from, to time.Time) (_ map[string]int, err error) {
+	defer s.observe("CountsByDay", time.Now(), &err)
+	ctx = s.withOp(ctx, "CountsByDay")
+
+	// SQLite's date() converts timestamps with an offset to UTC first.
+	query := `
+	SELECT date(created_at) AS day, COUNT(*)
+	FROM parcel
+	WHERE date(created_at) BETWEEN ? AND ? AND deleted_at IS NULL
+	GROUP BY day
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query,
+		from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[string]int{}
+
+	for rows.Next() {
+		var day string
+		var count int
+
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+
+		res[day] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AvgDeliveryDuration returns the mean time from creation to delivery of
+// the delivered parcels created between from and to, and how many there
+// were. With none it returns zero for both.
+func (s ParcelStore) AvgDeliveryDuration(from, to time.Time) (time.Duration, int, error) {
+	return s.AvgDeliveryDurationContext(context.Background(), from, to)
+}
+
+func (s ParcelStore) AvgDeliveryDurationContext(ctx context.Context, from, to time.Time) (_ time.Duration, _ int, err error) {
+	defer s.observe("AvgDeliveryDuration", time.Now(), &err)
+	ctx = s.withOp(ctx, "AvgDeliveryDuration")
+
+	query := `
+	SELECT created_at, delivered_at
+	FROM parcel
+	WHERE status = ? AND delivered_at IS NOT NULL
+		AND created_at >= ? AND created_at <= ? AND deleted_at IS NULL
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query,
+		ParcelStatusDelivered,
+		s.formatTime(from),
+		s.formatTime(to),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	var total time.Duration
+	var count int
+
+	for rows.Next() {
+		var createdAt, deliveredAt string
+		if err := rows.Scan(&createdAt, &deliveredAt); err != nil {
+			return 0, 0, err
+		}
+
+		created, err := s.parseTime(createdAt)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		delivered, err := time.Parse(time.RFC3339Nano, deliveredAt)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		total += delivered.Sub(created)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if count == 0 {
+		return 0, 0, nil
+	}
+
+	return total / time.Duration(count), count, nil
+}
+
+func (s ParcelStore) CountByClient(client int) (int, error) {
+	return s.CountByClientContext(context.Background(), client)
+}
+
+func (s ParcelStore) CountByClientContext(ctx context.Context, client int) (_ int, err error) {
+	defer s.observe("CountByClient", time.Now(), &err)
+	ctx = s.withOp(ctx, "CountByClient")
+
+	query := `
+	SELECT COUNT(*)
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	`
+
+	var count int
+	err = s.conn().QueryRowContext(ctx, query, client).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ListClients returns the clients that have parcels, in ascending order.
+func (s ParcelStore) ListClients() ([]int, error) {
+	return s.ListClientsContext(context.Background())
+}
+
+func (s ParcelStore) ListClientsContext(ctx context.Context) (_ []int, err error) {
+	defer s.observe("ListClients", time.Now(), &err)
+	ctx = s.withOp(ctx, "ListClients")
+
+	query := `
+	SELECT DISTINCT client
+	FROM parcel
+	WHERE deleted_at IS NULL
+	ORDER BY client
+	`
+
+	return s.listClients(ctx, query)
+}
+
+// ListClientsPage returns up to limit clients after afterClient, in
+// ascending order. Passing the last client seen walks all of them.
+func (s ParcelStore) ListClientsPage(afterClient, limit int) ([]int, error) {
+	return s.ListClientsPageContext(context.Background(), afterClient, limit)
+}
+
+func (s ParcelStore) ListClientsPageContext(ctx context.Context, afterClient, limit int) (_ []int, err error) {
+	defer s.observe("ListClientsPage", time.Now(), &err)
+	ctx = s.withOp(ctx, "ListClientsPage")
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit %d", ErrInvalidPage, limit)
+	}
+
+	query := `
+	SELECT DISTINCT client
+	FROM parcel
+	WHERE client > ? AND deleted_at IS NULL
+	ORDER BY client
+	LIMIT ?
+	`
+
+	return s.listClients(ctx, query, afterClient, limit)
+}
+
+func (s ParcelStore) listClients(ctx context.Context, query string, args ...any) ([]int, error) {
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []int{}
+	for rows.Next() {
+		var client int
+		if err := rows.Scan(&client); err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// GetByClientChangedSince returns the client's parcels changed after since,
+// least recently changed first, so the last one's UpdatedAt can be passed as
+// since on the next call.
+func (s ParcelStore) GetByClientChangedSince(client int, since time.Time) ([]Parcel, error) {
+	return s.GetByClientChangedSinceContext(context.Background(), client, since)
+}
+
+func (s ParcelStore) GetByClientChangedSinceContext(ctx context.Context, client int, since time.Time) (_ []Parcel, err error) {
+	defer s.observe("GetByClientChangedSince", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientChangedSince")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND updated_at > ? AND deleted_at IS NULL
+	ORDER BY updated_at, number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client, since.UTC().Format(timestampLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// GetByClientAfter returns up to limit parcels of the client numbered after
+// afterNumber. Passing the last number seen walks the whole set without
+// gaps or repeats, even while parcels are being added.
+func (s ParcelStore) GetByClientAfter(client, afterNumber, limit int) ([]Parcel, error) {
+	return s.GetByClientAfterContext(context.Background(), client, afterNumber, limit)
+}
+
+func (s ParcelStore) GetByClientAfterContext(ctx context.Context, client, afterNumber, limit int) (_ []Parcel, err error) {
+	defer s.observe("GetByClientAfter", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientAfter")
+
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND number > ? AND deleted_at IS NULL
+	ORDER BY number
+	LIMIT ?
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client, afterNumber, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// sortColumns lists the columns GetByClientSorted may order by. The column
+// name is interpolated into the query, so it must never come from the caller.
+var sortColumns = map[string]string{
+	"number":     "number",
+	"created_at": "created_at",
+}
+
+func (s ParcelStore) GetByClientSorted(client int, orderBy string, desc bool) ([]Parcel, error) {
+	return s.GetByClientSortedContext(context.Background(), client, orderBy, desc)
+}
+
+func (s ParcelStore) GetByClientSortedContext(ctx context.Context, client int, orderBy string, desc bool) (_ []Parcel, err error) {
+	defer s.observe("GetByClientSorted", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientSorted")
+
+	column, ok := sortColumns[orderBy]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSortField, orderBy)
+	}
+
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	ORDER BY ` + column + ` ` + direction + `, number ` + direction + `
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// SumPriceByClient totals the prices of the client's parcels per currency.
+func (s ParcelStore) SumPriceByClient(client int) (map[string]int64, error) {
+	return s.SumPriceByClientContext(context.Background(), client)
+}
+
+func (s ParcelStore) SumPriceByClientContext(ctx context.Context, client int) (_ map[string]int64, err error) {
+	defer s.observe("SumPriceByClient", time.Now(), &err)
+	ctx = s.withOp(ctx, "SumPriceByClient")
+
+	query := `
+	SELECT currency, SUM(price)
+	FROM parcel
+	WHERE client = ? AND price <> 0 AND deleted_at IS NULL
+	GROUP BY currency
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[string]int64{}
+
+	for rows.Next() {
+		var currency string
+		var total int64
+
+		if err := rows.Scan(&currency, &total); err != nil {
+			return nil, err
+		}
+
+		res[currency] = total
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (s ParcelStore) CountByStatus() (map[ParcelStatus]int, error) {
+	return s.CountByStatusContext(context.Background())
+}
+
+func (s ParcelStore) CountByStatusContext(ctx context.Context) (_ map[ParcelStatus]int, err error) {
+	defer s.observe("CountByStatus", time.Now(), &err)
+	ctx = s.withOp(ctx, "CountByStatus")
+
+	query := `
+	SELECT status, COUNT(*)
+	FROM parcel
+	WHERE deleted_at IS NULL
+	GROUP BY status
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanStatusCounts(rows)
+}
+
+// GetStatusCountsByClient counts the client's parcels per status. Statuses
+// without parcels are left out.
+func (s ParcelStore) GetStatusCountsByClient(client int) (map[ParcelStatus]int, error) {
+	return s.GetStatusCountsByClientContext(context.Background(), client)
+}
+
+func (s ParcelStore) GetStatusCountsByClientContext(ctx context.Context, client int) (_ map[ParcelStatus]int, err error) {
+	defer s.observe("GetStatusCountsByClient", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetStatusCountsByClient")
+
+	query := `
+	SELECT status, COUNT(*)
+	FROM parcel
+	WHERE client = ? AND deleted_at IS NULL
+	GROUP BY status
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanStatusCounts(rows)
+}
+
+func (s ParcelStore) GetByStatus(status ParcelStatus) ([]Parcel, error) {
+	return s.GetByStatusContext(context.Background(), status)
+}
+
+func (s ParcelStore) GetByStatusContext(ctx context.Context, status ParcelStatus) (_ []Parcel, err error) {
+	defer s.observe("GetByStatus", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByStatus")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE status = ? AND deleted_at IS NULL
+	ORDER BY number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// GetByStatusPage returns the given page of parcels in status, oldest first.
+// The total is counted in the same transaction as the page is read.
+func (s ParcelStore) GetByStatusPage(status ParcelStatus, page, size int) (ParcelPage, error) {
+	return s.GetByStatusPageContext(context.Background(), status, page, size)
+}
+
+func (s ParcelStore) GetByStatusPageContext(ctx context.Context, status ParcelStatus, page, size int) (_ ParcelPage, err error) {
+	defer s.observe("GetByStatusPage", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByStatusPage")
+
+	if page < 1 || size < 1 {
+		return ParcelPage{}, fmt.Errorf("%w: page %d of size %d", ErrInvalidPage, page, size)
+	}
+
+	res := ParcelPage{Page: page, PageSize: size}
+
+	err = s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT COUNT(*)
+		FROM parcel
+		WHERE status = ? AND deleted_at IS NULL
+		`
+
+		err := tx.QueryRowContext(ctx, query, status).Scan(&res.Total)
+		if err != nil {
+			return err
+		}
+
+		query = `
+		SELECT ` + parcelColumns + `
+		FROM parcel
+		WHERE status = ? AND deleted_at IS NULL
+		ORDER BY created_at, number
+		LIMIT ? OFFSET ?
+		`
+
+		rows, err := tx.QueryContext(ctx, query, status, size, (page-1)*size)
+		if err != nil {
+			return err
+		}
+
+		res.Items, err = scanParcels(rows)
+
+		return err
+	})
+	if err != nil {
+		return ParcelPage{}, err
+	}
+
+	return res, nil
+}
+
+// GetByStatusByPriority returns the parcels in status with the most urgent
+// first, and the oldest first among those of equal priority.
+func (s ParcelStore) GetByStatusByPriority(status ParcelStatus) ([]Parcel, error) {
+	return s.GetByStatusByPriorityContext(context.Background(), status)
+}
+
+func (s ParcelStore) GetByStatusByPriorityContext(ctx context.Context, status ParcelStatus) (_ []Parcel, err error) {
+	defer s.observe("GetByStatusByPriority", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByStatusByPriority")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE status = ? AND deleted_at IS NULL
+	ORDER BY priority DESC, created_at, number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// GetStaleInStatus returns the parcels in status that haven't changed for
+// longer than olderThan, least recently changed first.
+func (s ParcelStore) GetStaleInStatus(status ParcelStatus, olderThan time.Duration) ([]Parcel, error) {
+	return s.GetStaleInStatusContext(context.Background(), status, olderThan)
+}
+
+func (s ParcelStore) GetStaleInStatusContext(ctx context.Context, status ParcelStatus, olderThan time.Duration) (_ []Parcel, err error) {
+	defer s.observe("GetStaleInStatus", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetStaleInStatus")
+
+	cutoff := s.currentTime().Add(-olderThan).UTC().Format(timestampLayout)
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE status = ? AND updated_at < ? AND deleted_at IS NULL
+	ORDER BY updated_at, number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, status, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+func (s ParcelStore) GetByClientAndStatus(client int, status ParcelStatus) ([]Parcel, error) {
+	return s.GetByClientAndStatusContext(context.Background(), client, status)
+}
+
+func (s ParcelStore) GetByClientAndStatusContext(ctx context.Context, client int, status ParcelStatus) (_ []Parcel, err error) {
+	defer s.observe("GetByClientAndStatus", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientAndStatus")
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE client = ? AND status = ? AND deleted_at IS NULL
+	ORDER BY number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, client, status)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// GetByClientExcludingStatuses returns the client's parcels whose status
+// isn't in exclude, numbered in ascending order. With nothing to exclude it
+// returns all of them.
+func (s ParcelStore) GetByClientExcludingStatuses(client int, exclude []ParcelStatus) ([]Parcel, error) {
+	return s.GetByClientExcludingStatusesContext(context.Background(), client, exclude)
+}
+
+func (s ParcelStore) GetByClientExcludingStatusesContext(ctx context.Context, client int, exclude []ParcelStatus) (_ []Parcel, err error) {
+	defer s.observe("GetByClientExcludingStatuses", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetByClientExcludingStatuses")
+
+	args := []any{client}
+	where := "client = ? AND deleted_at IS NULL"
+
+	if len(exclude) > 0 {
+		for _, status := range exclude {
+			args = append(args, status)
+		}
+		where += " AND status NOT IN (" + placeholders(len(exclude)) + ")"
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE ` + where + `
+	ORDER BY number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+func (s ParcelStore) SetStatus(number int, status ParcelStatus) error {
+	return s.SetStatusContext(context.Background(), number, status)
+}
+
+func (s ParcelStore) SetStatusContext(ctx context.Context, number int, status ParcelStatus) (err error) {
+	defer s.observe("SetStatus", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetStatus")
+
+	if !status.IsValid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return s.inTx(ctx, func(tx querier) error {
+		return s.setStatus(ctx, tx, number, StatusEvent{Status: status})
+	})
+}
+
+// SetStatusBy is SetStatus that records who made the change in the status
+// history. An empty actor is recorded as SystemActor, like SetStatus.
+func (s ParcelStore) SetStatusBy(number int, status ParcelStatus, actor string) error {
+	return s.SetStatusByContext(context.Background(), number, status, actor)
+}
+
+func (s ParcelStore) SetStatusByContext(ctx context.Context, number int, status ParcelStatus, actor string) (err error) {
+	defer s.observe("SetStatusBy", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetStatusBy")
+
+	if !status.IsValid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return s.inTx(ctx, func(tx querier) error {
+		return s.setStatus(ctx, tx, number, StatusEvent{Status: status, Actor: actor})
+	})
+}
+
+// setStatus moves a parcel to e.Status and adds e to its history.
+func (s ParcelStore) setStatus(ctx context.Context, tx querier, number int, e StatusEvent) error {
+	return s.changeStatus(ctx, tx, number, e, canTransition)
+}
+
+// changeStatus is setStatus with allowed deciding which transitions are
+// valid.
+func (s ParcelStore) changeStatus(ctx context.Context, tx querier, number int, e StatusEvent, allowed func(from, to ParcelStatus) bool) error {
+	status := e.Status
+
+	query := `
+	SELECT status, delivered_at
+	FROM parcel
+	WHERE number = ? AND deleted_at IS NULL
+	`
+
+	var current ParcelStatus
+	var currentDeliveredAt sql.NullString
+	err := tx.QueryRowContext(ctx, query, number).Scan(&current, &currentDeliveredAt)
+	if err != nil {
+		return notFound(err)
+	}
+
+	if !allowed(current, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, current, status)
+	}
+
+	query = `
+	UPDATE parcel
+	SET status = ?, updated_at = ?, delivered_at = ?, return_reason = ?, version = version + 1
+	WHERE number = ?
+	`
+
+	var returnReason sql.NullString
+	if status == ParcelStatusReturned {
+		returnReason = nullString(e.Reason)
+	}
+
+	updatedAt := s.now()
+	err = checkAffected(tx.ExecContext(ctx, query,
+		status, updatedAt, deliveredAt(status, currentDeliveredAt, updatedAt), returnReason, number))
+	if err != nil {
+		return err
+	}
+
+	e.ChangedAt = updatedAt
+
+	return addStatusEvent(ctx, tx, number, e)
+}
+
+// Return marks a sent or registered parcel returned and records the reason
+// on the parcel and in its status history.
+func (s ParcelStore) Return(number int, reason string) error {
+	return s.ReturnContext(context.Background(), number, reason)
+}
+
+func (s ParcelStore) ReturnContext(ctx context.Context, number int, reason string) (err error) {
+	defer s.observe("Return", time.Now(), &err)
+	ctx = s.withOp(ctx, "Return")
+
+	return s.inTx(ctx, func(tx querier) error {
+		return s.setStatus(ctx, tx, number, StatusEvent{Status: ParcelStatusReturned, Reason: reason})
+	})
+}
+
+// Requeue moves a returned parcel back to registered for another attempt,
+// clearing its return reason. Its history keeps the return.
+func (s ParcelStore) Requeue(number int) error {
+	return s.RequeueContext(context.Background(), number)
+}
+
+func (s ParcelStore) RequeueContext(ctx context.Context, number int) (err error) {
+	defer s.observe("Requeue", time.Now(), &err)
+	ctx = s.withOp(ctx, "Requeue")
+
+	return s.inTx(ctx, func(tx querier) error {
+		return s.changeStatus(ctx, tx, number, StatusEvent{Status: ParcelStatusRegistered},
+			func(from, to ParcelStatus) bool {
+				return from == ParcelStatusReturned
+			})
+	})
+}
+
+// SetStatusBatch moves every listed parcel to status in one transaction and
+// returns how many changed. If any of them can't make the transition,
+// none are changed.
+func (s ParcelStore) SetStatusBatch(numbers []int, status ParcelStatus) (updated int, err error) {
+	return s.SetStatusBatchContext(context.Background(), numbers, status)
+}
+
+func (s ParcelStore) SetStatusBatchContext(ctx context.Context, numbers []int, status ParcelStatus) (updated int, err error) {
+	defer s.observe("SetStatusBatch", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetStatusBatch")
+
+	if !status.IsValid() {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	err = s.inTx(ctx, func(tx querier) error {
+		updated = 0
+		seen := make(map[int]bool, len(numbers))
+
+		for _, number := range numbers {
+			if seen[number] {
+				continue
+			}
+			seen[number] = true
+
+			if err := s.setStatus(ctx, tx, number, StatusEvent{Status: status}); err != nil {
+				return fmt.Errorf("parcel %d: %w", number, err)
+			}
+			updated++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return updated, nil
+}
+
+// BulkTransition moves every parcel in status from created before the
+// cutoff to status to, in one transaction, and returns how many changed.
+// A transition that isn't allowed fails before anything is read.
+func (s ParcelStore) BulkTransition(from, to ParcelStatus, before time.Time) (count int, err error) {
+	return s.BulkTransitionContext(context.Background(), from, to, before)
+}
+
+func (s ParcelStore) BulkTransitionContext(ctx context.Context, from, to ParcelStatus, before time.Time) (count int, err error) {
+	defer s.observe("BulkTransition", time.Now(), &err)
+	ctx = s.withOp(ctx, "BulkTransition")
+
+	for _, status := range []ParcelStatus{from, to} {
+		if !status.IsValid() {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+		}
+	}
+
+	if !canTransition(from, to) {
+		return 0, fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, from, to)
+	}
+
+	err = s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT number
+		FROM parcel
+		WHERE status = ? AND created_at < ? AND deleted_at IS NULL
+		ORDER BY number
+		`
+
+		rows, err := tx.QueryContext(ctx, query, from, s.formatTime(before))
+		if err != nil {
+			return err
+		}
+
+		var numbers []int
+		for rows.Next() {
+			var number int
+			if err := rows.Scan(&number); err != nil {
+				rows.Close()
+				return err
+			}
+			numbers = append(numbers, number)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, number := range numbers {
+			if err := s.setStatus(ctx, tx, number, StatusEvent{Status: to}); err != nil {
+				return fmt.Errorf("parcel %d: %w", number, err)
+			}
+		}
+		count = len(numbers)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s ParcelStore) GetStatusHistory(number int) ([]StatusEvent, error) {
+	return s.GetStatusHistoryContext(context.Background(), number)
+}
+
+func (s ParcelStore) GetStatusHistoryContext(ctx context.Context, number int) (_ []StatusEvent, err error) {
+	defer s.observe("GetStatusHistory", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetStatusHistory")
+
+	return statusHistory(ctx, s.conn(), number)
+}
+
+// GetWithHistory returns a parcel together with its status history, read in
+// one transaction.
+func (s ParcelStore) GetWithHistory(number int) (Parcel, []StatusEvent, error) {
+	return s.GetWithHistoryContext(context.Background(), number)
+}
+
+func (s ParcelStore) GetWithHistoryContext(ctx context.Context, number int) (p Parcel, events []StatusEvent, err error) {
+	defer s.observe("GetWithHistory", time.Now(), &err)
+	ctx = s.withOp(ctx, "GetWithHistory")
+
+	err = s.inTx(ctx, func(tx querier) error {
+		var err error
+
+		p, err = getParcel(ctx, tx, number)
+		if err != nil {
+			return err
+		}
+
+		events, err = statusHistory(ctx, tx, number)
+
+		return err
+	})
+	if err != nil {
+		return Parcel{}, nil, err
+	}
+
+	return p, events, nil
+}
+
+func statusHistory(ctx context.Context, q querier, number int) ([]StatusEvent, error) {
+	query := `
+	SELECT status, changed_at, reason, actor
+	FROM parcel_status_history
+	WHERE parcel_number = ?
+	ORDER BY changed_at, id
+	`
+
+	rows, err := q.QueryContext(ctx, query, number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []StatusEvent{}
+
+	for rows.Next() {
+		e := StatusEvent{}
+
+		err := rows.Scan(&e.Status, &e.ChangedAt, &e.Reason, &e.Actor)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SystemActor is recorded for status changes made without naming an actor.
+const SystemActor = "system"
+
+func addStatusEvent(ctx context.Context, q querier, number int, e StatusEvent) error {
+	if e.Actor == "" {
+		e.Actor = SystemActor
+	}
+
+	query := `
+	INSERT INTO parcel_status_history (parcel_number, status, changed_at, reason, actor)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := q.ExecContext(ctx, query, number, e.Status, e.ChangedAt, e.Reason, e.Actor)
+
+	return err
+}
+
+// Update changes address and status in one statement. An empty address keeps
+// the current one; as with SetAddress, the address is only changed while the
+// parcel is still registered.
+func (s ParcelStore) Update(number int, address string, status ParcelStatus) error {
+	return s.UpdateContext(context.Background(), number, address, status)
+}
+
+func (s ParcelStore) UpdateContext(ctx context.Context, number int, address string, status ParcelStatus) (err error) {
+	defer s.observe("Update", time.Now(), &err)
+	ctx = s.withOp(ctx, "Update")
+
+	if !status.IsValid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT status, address, address_raw, delivered_at
+		FROM parcel
+		WHERE number = ? AND deleted_at IS NULL
+		`
+
+		var currentStatus ParcelStatus
+		var currentAddress string
+		var currentAddressRaw, currentDeliveredAt sql.NullString
+		err := tx.QueryRowContext(ctx, query, number).
+			Scan(&currentStatus, &currentAddress, &currentAddressRaw, &currentDeliveredAt)
+		if err != nil {
+			return notFound(err)
+		}
+
+		if status != currentStatus && !canTransition(currentStatus, status) {
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, currentStatus, status)
+		}
+
+		addressRaw := address
+		if address == "" || currentStatus != ParcelStatusRegistered {
+			address = currentAddress
+			addressRaw = currentAddressRaw.String
+		}
+
+		query = `
+		UPDATE parcel
+		SET address = ?, address_raw = ?, status = ?, updated_at = ?, delivered_at = ?, version = version + 1
+		WHERE number = ?
+		`
+
+		updatedAt := s.now()
+		err = checkAffected(tx.ExecContext(ctx, query,
+			pii(normalizeAddress(address)), pii(addressRaw), status, updatedAt,
+			deliveredAt(status, currentDeliveredAt, updatedAt), number))
+		if err != nil {
+			return err
+		}
+
+		if status == currentStatus {
+			return nil
+		}
+
+		return addStatusEvent(ctx, tx, number, StatusEvent{Status: status, ChangedAt: updatedAt})
+	})
+}
+
+func (s ParcelStore) SetAddress(number int, address string) error {
+	return s.SetAddressContext(context.Background(), number, address)
+}
+
+func (s ParcelStore) SetAddressContext(ctx context.Context, number int, address string) (err error) {
+	defer s.observe("SetAddress", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetAddress")
+
+	return s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT status
+		FROM parcel
+		WHERE number = ? AND deleted_at IS NULL
+		`
+
+		var status ParcelStatus
+		err := tx.QueryRowContext(ctx, query, number).Scan(&status)
+		if err != nil {
+			return notFound(err)
+		}
+
+		if status != ParcelStatusRegistered {
+			return nil
+		}
+
+		query = `
+		UPDATE parcel
+		SET address = ?, address_raw = ?, updated_at = ?, version = version + 1
+		WHERE number = ?
+		`
+
+		return checkAffected(tx.ExecContext(ctx, query,
+			pii(normalizeAddress(address)), pii(address), s.now(), number))
+	})
 }
 
-func NewParcelStore(db *sql.DB) ParcelStore {
-	return ParcelStore{db: db}
+// SetAddressVersioned is SetAddress that only applies when the parcel is still
+// at expectedVersion, and returns ErrVersionConflict if it was changed since.
+func (s ParcelStore) SetAddressVersioned(number int, address string, expectedVersion int) error {
+	return s.SetAddressVersionedContext(context.Background(), number, address, expectedVersion)
 }
 
-func (s ParcelStore) Add(p Parcel) (int, error) {
+func (s ParcelStore) SetAddressVersionedContext(ctx context.Context, number int, address string, expectedVersion int) (err error) {
+	defer s.observe("SetAddressVersioned", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetAddressVersioned")
+
+	return s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT status, version
+		FROM parcel
+		WHERE number = ? AND deleted_at IS NULL
+		`
+
+		var status ParcelStatus
+		var version int
+		err := tx.QueryRowContext(ctx, query, number).Scan(&status, &version)
+		if err != nil {
+			return notFound(err)
+		}
+
+		if version != expectedVersion {
+			return fmt.Errorf("%w: parcel %d is at version %d, not %d", ErrVersionConflict, number, version, expectedVersion)
+		}
+
+		if status != ParcelStatusRegistered {
+			return nil
+		}
+
+		query = `
+		UPDATE parcel
+		SET address = ?, address_raw = ?, updated_at = ?, version = version + 1
+		WHERE number = ? AND version = ?
+		`
+
+		result, err := tx.ExecContext(ctx, query,
+			pii(normalizeAddress(address)), pii(address), s.now(), number, expectedVersion)
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: parcel %d", ErrVersionConflict, number)
+		}
+
+		return nil
+	})
+}
+
+func (s ParcelStore) SetWeight(number int, weight float64) error {
+	return s.SetWeightContext(context.Background(), number, weight)
+}
+
+func (s ParcelStore) SetWeightContext(ctx context.Context, number int, weight float64) (err error) {
+	defer s.observe("SetWeight", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetWeight")
+
+	if weight < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidWeight, weight)
+	}
+
 	query := `
-	INSERT INTO parcel (client, status, address, created_at)
-	VALUES (?, ?, ?, ?)
+	UPDATE parcel
+	SET weight = ?, updated_at = ?, version = version + 1
+	WHERE number = ? AND deleted_at IS NULL
 	`
 
-	result, err := s.db.Exec(query,
-		p.Client,
-		p.Status,
-		p.Address,
-		p.CreatedAt,
-	)
+	return s.retry(ctx, func() error {
+		return checkAffected(s.conn().ExecContext(ctx, query, weight, s.now(), number))
+	})
+}
+
+// SetPriority changes how urgent a parcel is; see GetByStatusByPriority.
+func (s ParcelStore) SetPriority(number, priority int) error {
+	return s.SetPriorityContext(context.Background(), number, priority)
+}
+
+func (s ParcelStore) SetPriorityContext(ctx context.Context, number, priority int) (err error) {
+	defer s.observe("SetPriority", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetPriority")
+
+	query := `
+	UPDATE parcel
+	SET priority = ?, updated_at = ?, version = version + 1
+	WHERE number = ? AND deleted_at IS NULL
+	`
+
+	return s.retry(ctx, func() error {
+		return checkAffected(s.conn().ExecContext(ctx, query, priority, s.now(), number))
+	})
+}
+
+func (s ParcelStore) SetRecipient(number int, name, phone string) error {
+	return s.SetRecipientContext(context.Background(), number, name, phone)
+}
+
+func (s ParcelStore) SetRecipientContext(ctx context.Context, number int, name, phone string) (err error) {
+	defer s.observe("SetRecipient", time.Now(), &err)
+	ctx = s.withOp(ctx, "SetRecipient")
+
+	if err := validatePhone(phone); err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE parcel
+	SET recipient = ?, phone = ?, updated_at = ?, version = version + 1
+	WHERE number = ? AND deleted_at IS NULL
+	`
+
+	return s.retry(ctx, func() error {
+		return checkAffected(s.conn().ExecContext(ctx, query, pii(name), pii(phone), s.now(), number))
+	})
+}
+
+func (s ParcelStore) Delete(number int) error {
+	return s.DeleteContext(context.Background(), number)
+}
+
+func (s ParcelStore) DeleteContext(ctx context.Context, number int) (err error) {
+	defer s.observe("Delete", time.Now(), &err)
+	ctx = s.withOp(ctx, "Delete")
+
+	return s.inTx(ctx, func(tx querier) error {
+		query := `
+		SELECT status
+		FROM parcel
+		WHERE number = ? AND deleted_at IS NULL
+		`
+
+		var status ParcelStatus
+		err := tx.QueryRowContext(ctx, query, number).Scan(&status)
+		if err != nil {
+			return notFound(err)
+		}
+
+		if status != ParcelStatusRegistered {
+			return fmt.Errorf("%w: parcel %d is %s", ErrDeleteNotAllowed, number, status)
+		}
+
+		query = `
+		UPDATE parcel
+		SET deleted_at = ?, updated_at = ?, version = version + 1
+		WHERE number = ?
+		`
+
+		deletedAt := s.now()
+
+		return checkAffected(tx.ExecContext(ctx, query, deletedAt, deletedAt, number))
+	})
+}
+
+// DeleteByClient deletes the client's parcels that are still registered and
+// returns how many were deleted. Parcels in other statuses are kept. With
+// dryRun it only counts them.
+func (s ParcelStore) DeleteByClient(client int, dryRun bool) (deleted int, err error) {
+	return s.DeleteByClientContext(context.Background(), client, dryRun)
+}
+
+func (s ParcelStore) DeleteByClientContext(ctx context.Context, client int, dryRun bool) (deleted int, err error) {
+	defer s.observe("DeleteByClient", time.Now(), &err)
+	ctx = s.withOp(ctx, "DeleteByClient")
+
+	if dryRun {
+		query := `
+		SELECT COUNT(*)
+		FROM parcel
+		WHERE client = ? AND status = ? AND deleted_at IS NULL
+		`
+
+		err = s.conn().QueryRowContext(ctx, query, client, ParcelStatusRegistered).Scan(&deleted)
+		if err != nil {
+			return 0, err
+		}
+
+		return deleted, nil
+	}
+
+	err = s.inTx(ctx, func(tx querier) error {
+		query := `
+		UPDATE parcel
+		SET deleted_at = ?, updated_at = ?, version = version + 1
+		WHERE client = ? AND status = ? AND deleted_at IS NULL
+		`
+
+		deletedAt := s.now()
+
+		result, err := tx.ExecContext(ctx, query, deletedAt, deletedAt, client, ParcelStatusRegistered)
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		deleted = int(n)
+
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	id, err := result.LastInsertId()
+	return deleted, nil
+}
+
+// PurgeDeleted permanently removes parcels soft-deleted before the cutoff,
+// along with their status history and metadata, and returns how many were
+// removed.
+func (s ParcelStore) PurgeDeleted(before time.Time) (purged int, err error) {
+	return s.PurgeDeletedContext(context.Background(), before)
+}
+
+func (s ParcelStore) PurgeDeletedContext(ctx context.Context, before time.Time) (purged int, err error) {
+	defer s.observe("PurgeDeleted", time.Now(), &err)
+	ctx = s.withOp(ctx, "PurgeDeleted")
+
+	cutoff := before.UTC().Format(timestampLayout)
+
+	err = s.inTx(ctx, func(tx querier) error {
+		for _, table := range []string{"parcel_status_history", "parcel_audit", "parcel_idempotency_keys", "parcel_meta"} {
+			query := `
+			DELETE FROM ` + table + `
+			WHERE parcel_number IN (
+				SELECT number FROM parcel WHERE deleted_at IS NOT NULL AND deleted_at < ?
+			)
+			`
+
+			_, err := tx.ExecContext(ctx, query, cutoff)
+			if err != nil {
+				return err
+			}
+		}
+
+		query := `
+		DELETE FROM parcel
+		WHERE deleted_at IS NOT NULL AND deleted_at < ?
+		`
+
+		result, err := tx.ExecContext(ctx, query, cutoff)
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		purged = int(n)
+
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	return int(id), nil
+	return purged, nil
 }
 
-func (s ParcelStore) Get(number int) (Parcel, error) {
+func (s ParcelStore) Restore(number int) error {
+	return s.RestoreContext(context.Background(), number)
+}
+
+func (s ParcelStore) RestoreContext(ctx context.Context, number int) (err error) {
+	defer s.observe("Restore", time.Now(), &err)
+	ctx = s.withOp(ctx, "Restore")
+
 	query := `
-	SELECT number, client, status, address, created_at
-	FROM parcel
-	WHERE number = ?
+	UPDATE parcel
+	SET deleted_at = NULL, updated_at = ?, version = version + 1
+	WHERE number = ? AND deleted_at IS NOT NULL
 	`
 
-	p := Parcel{}
+	return s.retry(ctx, func() error {
+		return checkAffected(s.conn().ExecContext(ctx, query, s.now(), number))
+	})
+}
+
+func (s ParcelStore) BeginTx(ctx context.Context) (*ParcelStoreTx, error) {
+	conn, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	txStore := s
+	txStore.tx = tx
 
-	row := s.db.QueryRow(query, number)
-	err := row.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt)
+	return &ParcelStoreTx{ParcelStore: txStore, conn: conn}, nil
+}
+
+// WithTx runs fn with a store bound to a new transaction, committing it when
+// fn returns nil and rolling it back when fn fails or panics. A store that is
+// already bound to a transaction passes itself to fn.
+func (s ParcelStore) WithTx(ctx context.Context, fn func(txStore *ParcelStore) error) error {
+	if s.tx != nil {
+		return fn(&s)
+	}
 
+	tx, err := s.BeginTx(ctx)
 	if err != nil {
-		return p, err
+		return err
 	}
 
-	return p, nil
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&tx.ParcelStore); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (s ParcelStore) GetByClient(client int) ([]Parcel, error) {
-	query := `
-	SELECT number, client, status, address, created_at
-	FROM parcel
-	WHERE client = ?
-	`
+// beginTx starts a transaction on a connection of its own, so that a failed
+// commit can be cleaned up before the connection goes back to the pool.
+func (s ParcelStore) beginTx(ctx context.Context) (*sql.Conn, *sql.Tx, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	rows, err := s.db.Query(query, client)
+	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		conn.Close()
+		return nil, nil, err
 	}
-	defer rows.Close()
 
-	var res []Parcel
+	return conn, tx, nil
+}
 
-	for rows.Next() {
-		p := Parcel{}
+// commit commits tx. When COMMIT fails, for example with SQLITE_BUSY, SQLite
+// keeps the transaction open while database/sql already treats it as done,
+// so it is rolled back here rather than left holding locks on conn. A failed
+// rollback is joined to the commit error.
+func commit(conn *sql.Conn, tx *sql.Tx) error {
+	err := tx.Commit()
+	if err != nil {
+		if _, rbErr := conn.ExecContext(context.Background(), "ROLLBACK"); rbErr != nil {
+			err = errors.Join(err, fmt.Errorf("rollback: %w", rbErr))
+		}
+	}
+
+	return err
+}
+
+func (s ParcelStore) conn() querier {
+	return s.querier(s.tx)
+}
 
-		err := rows.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt)
+func (s ParcelStore) bind(q querier) querier {
+	if !s.dialect.numberedArgs && (s.table == "" || s.table == defaultTable) {
+		return q
+	}
+
+	return boundQuerier{q: q, d: s.dialect, table: s.table}
+}
+
+// inTx runs fn in a new transaction, or in the store's own transaction when
+// the store is already bound to one. A new transaction is retried as a whole
+// while the database is busy, so fn must be safe to run more than once.
+func (s ParcelStore) inTx(ctx context.Context, fn func(tx querier) error) error {
+	if s.tx != nil {
+		return fn(s.querier(s.tx))
+	}
+
+	return s.retry(ctx, func() error {
+		conn, tx, err := s.beginTx(ctx)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		defer conn.Close()
+		defer tx.Rollback()
 
-		res = append(res, p)
+		if err := fn(s.querier(tx)); err != nil {
+			return err
+		}
+
+		return commit(conn, tx)
+	})
+}
+
+// retry runs fn again with exponential backoff while it fails because the
+// database is busy or locked. Inside a caller's transaction fn runs once,
+// since only the caller can restart the transaction. With WithWriteLock it
+// holds the lock throughout.
+func (s ParcelStore) retry(ctx context.Context, fn func() error) error {
+	if s.writeMu != nil && s.tx == nil {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	delay := s.retryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || s.tx != nil || attempt >= s.retries || !isBusy(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if delay < maxRetryDelay {
+			delay *= 2
+		}
 	}
+}
 
-	return res, nil
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func (s ParcelStore) SetStatus(number int, status string) error {
-	query := `
-	UPDATE parcel
-	SET status = ?
-	WHERE number = ?
-	`
-	_, err := s.db.Exec(query, status, number)
+// scanParcel reads a row of parcelColumns. The columns that may be NULL are
+// scanned as sql.NullString and read back as zero values, so rows written
+// before a column was added need no backfill.
+func scanParcel(row rowScanner) (Parcel, error) {
+	return newParcelScanner().scan(row)
+}
 
-	return err
+// parcelScanner holds the scan targets for a row of parcelColumns, so that
+// reading many rows sets them up once.
+type parcelScanner struct {
+	p                                                   Parcel
+	externalCode, deliveredAt, returnReason, addressRaw sql.NullString
+	dest                                                []any
 }
 
-func (s ParcelStore) SetAddress(number int, address string) error {
-	parcel, err := s.Get(number)
+func newParcelScanner() *parcelScanner {
+	ps := &parcelScanner{}
+	ps.dest = []any{
+		&ps.p.Number,
+		&ps.p.Client,
+		&ps.p.Status,
+		&ps.p.Address,
+		&ps.p.CreatedAt,
+		&ps.p.UpdatedAt,
+		&ps.p.Weight,
+		&ps.p.Recipient,
+		&ps.p.Phone,
+		&ps.externalCode,
+		&ps.deliveredAt,
+		&ps.p.Price,
+		&ps.p.Currency,
+		&ps.p.Version,
+		&ps.returnReason,
+		&ps.addressRaw,
+		&ps.p.Priority,
+	}
+
+	return ps
+}
+
+func (ps *parcelScanner) scan(row rowScanner) (Parcel, error) {
+	ps.p = Parcel{}
+
+	err := row.Scan(ps.dest...)
 	if err != nil {
-		return err
+		return ps.p, notFound(err)
 	}
 
-	if parcel.Status != ParcelStatusRegistered {
-		return nil
+	p := ps.p
+	p.ExternalCode = ps.externalCode.String
+	p.ReturnReason = ps.returnReason.String
+
+	// Parcels stored before address_raw was added have none.
+	p.AddressRaw = ps.addressRaw.String
+	if p.AddressRaw == "" {
+		p.AddressRaw = p.Address
 	}
 
-	query := `
-	UPDATE parcel
-	SET address = ?
-	WHERE	number = ?
-	`
-	_, err = s.db.Exec(query, address, number)
+	if ps.deliveredAt.Valid {
+		t, err := time.Parse(time.RFC3339Nano, ps.deliveredAt.String)
+		if err != nil {
+			return p, err
+		}
+		p.DeliveredAt = &t
+	}
 
-	return err
+	return p, nil
 }
 
-func (s ParcelStore) Delete(number int) error {
-	parcel, err := s.Get(number)
+// checkAffected reports ErrParcelNotFound when a statement matched no rows.
+// Rows updated to their current values still count as matched.
+func checkAffected(result sql.Result, err error) error {
 	if err != nil {
 		return err
 	}
 
-	if parcel.Status != ParcelStatusRegistered {
-		return nil
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
 	}
 
-	query := `
-	DELETE FROM parcel
-	WHERE number = ?
-	`
+	if n == 0 {
+		return ErrParcelNotFound
+	}
+
+	return nil
+}
 
-	_, err = s.db.Exec(query, number)
+// notFound translates sql.ErrNoRows so callers don't depend on database/sql.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrParcelNotFound
+	}
 
 	return err
 }
+
+func scanStatusCounts(rows *sql.Rows) (map[ParcelStatus]int, error) {
+	defer rows.Close()
+
+	res := map[ParcelStatus]int{}
+
+	for rows.Next() {
+		var status ParcelStatus
+		var count int
+
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+
+		res[status] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func scanParcels(rows *sql.Rows) ([]Parcel, error) {
+	res := []Parcel{}
+
+	err := eachParcel(rows, func(p Parcel) error {
+		res = append(res, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// eachParcel calls fn for every row, stopping at the first error. rows is
+// always closed.
+func eachParcel(rows *sql.Rows, fn func(Parcel) error) error {
+	defer rows.Close()
+
+	ps := newParcelScanner()
+	for rows.Next() {
+		p, err := ps.scan(rows)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+type ParcelStoreTx struct {
+	ParcelStore
+	conn *sql.Conn
+}
+
+func (t *ParcelStoreTx) Tx() *sql.Tx {
+	return t.tx
+}
+
+func (t *ParcelStoreTx) Commit() error {
+	defer t.conn.Close()
+
+	return commit(t.conn, t.tx)
+}
+
+func (t *ParcelStoreTx) Rollback() error {
+	defer t.conn.Close()
+
+	return t.tx.Rollback()
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"math/rand"
+	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,22 +20,30 @@ var (
 	randRange  = rand.New(randSource)
 )
 
-func openTestDB(t *testing.T) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", "file::memory:?cache=shared")
+// testDriver and testDSN are overridden by the backend-specific test files
+// built with the matching tag.
+var (
+	testDriver = "sqlite"
+	testDSN    = "file::memory:?cache=shared"
+)
+
+func openTestDB(t testing.TB) (*sql.DB, error) {
+	if testDSN == "" {
+		t.Skipf("no DSN configured for %s", testDriver)
+	}
+
+	db, err := sql.Open(testDriver, testDSN)
 	if err != nil {
 		return nil, err
 	}
 
-	const createTableQuery = `
-	CREATE TABLE IF NOT EXISTS parcel (
-		number      INTEGER PRIMARY KEY AUTOINCREMENT,
-		client      INTEGER NOT NULL,
-		status      TEXT NOT NULL,
-		address     TEXT NOT NULL,
-		created_at  TEXT NOT NULL
-	);`
+	err = dropTestTables(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	_, err = db.Exec(createTableQuery)
+	err = Migrate(db)
 	if err != nil {
 		db.Close()
 		return nil, err
@@ -38,6 +52,24 @@ func openTestDB(t *testing.T) (*sql.DB, error) {
 	return db, nil
 }
 
+func dropTestTables(db *sql.DB) error {
+	for _, table := range []string{"schema_migrations", "parcel_fts", "parcel_archive", "parcel_audit", "parcel_idempotency_keys", "parcel_meta", "parcel_status_history", "parcel"} {
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func skipUnlessSQLite(t *testing.T) {
+	t.Helper()
+
+	if dialectFor(testDriver).name != sqliteDialect.name {
+		t.Skipf("test relies on SQLite-specific SQL, running against %s", testDriver)
+	}
+}
+
 func getTestParcel() Parcel {
 	return Parcel{
 		Client:    1000,
@@ -47,6 +79,35 @@ func getTestParcel() Parcel {
 	}
 }
 
+// requireParcelEqual compares parcels, ignoring fields stamped by the store.
+func requireParcelEqual(t *testing.T, expected, actual Parcel) {
+	t.Helper()
+
+	require.NotEmpty(t, actual.UpdatedAt)
+	expected.UpdatedAt = actual.UpdatedAt
+
+	require.Equal(t, actual.Status == ParcelStatusDelivered, actual.DeliveredAt != nil)
+	expected.DeliveredAt = actual.DeliveredAt
+
+	require.Positive(t, actual.Version)
+	expected.Version = actual.Version
+
+	if expected.AddressRaw == "" {
+		expected.AddressRaw = expected.Address
+	}
+
+	require.Equal(t, expected, actual)
+}
+
+func requireParcelsEqual(t *testing.T, expected, actual []Parcel) {
+	t.Helper()
+
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		requireParcelEqual(t, expected[i], actual[i])
+	}
+}
+
 func TestAddGetDelete(t *testing.T) {
 	db, err := openTestDB(t)
 	require.NoError(t, err)
@@ -64,13 +125,13 @@ func TestAddGetDelete(t *testing.T) {
 	storedParcel, err := store.Get(parcel.Number)
 	require.NoError(t, err)
 
-	require.Equal(t, parcel, storedParcel)
+	requireParcelEqual(t, parcel, storedParcel)
 
 	err = store.Delete(parcel.Number)
 	require.NoError(t, err)
 
 	_, err = store.Get(parcel.Number)
-	require.ErrorIs(t, err, sql.ErrNoRows)
+	require.ErrorIs(t, err, ErrParcelNotFound)
 }
 
 func TestSetAddress(t *testing.T) {
@@ -165,6 +226,3257 @@ func TestGetByClient(t *testing.T) {
 
 		require.True(t, ok)
 
-		require.Equal(t, expectedParcel, parcel)
+		requireParcelEqual(t, expectedParcel, parcel)
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Add", func() error { _, err := store.AddContext(ctx, parcel); return err }},
+		{"Get", func() error { _, err := store.GetContext(ctx, id); return err }},
+		{"GetByClient", func() error { _, err := store.GetByClientContext(ctx, parcel.Client); return err }},
+		{"SetStatus", func() error { return store.SetStatusContext(ctx, id, ParcelStatusSent) }},
+		{"SetAddress", func() error { return store.SetAddressContext(ctx, id, "new test address") }},
+		{"Delete", func() error { return store.DeleteContext(ctx, id) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorIs(t, tt.call(), context.Canceled)
+		})
+	}
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, parcel.Status, storedParcel.Status)
+
+	// Canceling while rows are being read stops the iteration.
+	_, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	var seen int
+	err = store.GetByClientFuncContext(ctx, parcel.Client, func(Parcel) error {
+		seen++
+		cancel()
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, seen)
+}
+
+func TestSetStatusTransitions(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	tests := []struct {
+		from    ParcelStatus
+		to      ParcelStatus
+		allowed bool
+	}{
+		{ParcelStatusRegistered, ParcelStatusRegistered, false},
+		{ParcelStatusRegistered, ParcelStatusSent, true},
+		{ParcelStatusRegistered, ParcelStatusDelivered, false},
+		{ParcelStatusRegistered, ParcelStatusReturned, true},
+		{ParcelStatusSent, ParcelStatusRegistered, false},
+		{ParcelStatusSent, ParcelStatusSent, false},
+		{ParcelStatusSent, ParcelStatusDelivered, true},
+		{ParcelStatusSent, ParcelStatusReturned, true},
+		{ParcelStatusDelivered, ParcelStatusRegistered, false},
+		{ParcelStatusDelivered, ParcelStatusSent, false},
+		{ParcelStatusDelivered, ParcelStatusDelivered, false},
+		{ParcelStatusDelivered, ParcelStatusReturned, false},
+		{ParcelStatusReturned, ParcelStatusRegistered, false},
+		{ParcelStatusReturned, ParcelStatusSent, false},
+		{ParcelStatusReturned, ParcelStatusDelivered, false},
+		{ParcelStatusReturned, ParcelStatusReturned, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from+"->"+tt.to), func(t *testing.T) {
+			parcel := getTestParcel()
+			parcel.Status = tt.from
+
+			id, err := store.Add(parcel)
+			require.NoError(t, err)
+
+			err = store.SetStatus(id, tt.to)
+
+			storedParcel, getErr := store.Get(id)
+			require.NoError(t, getErr)
+
+			if tt.allowed {
+				require.NoError(t, err)
+				require.Equal(t, tt.to, storedParcel.Status)
+			} else {
+				require.ErrorIs(t, err, ErrInvalidStatusTransition)
+				require.ErrorContains(t, err, string(tt.from))
+				require.ErrorContains(t, err, string(tt.to))
+				require.Equal(t, tt.from, storedParcel.Status)
+			}
+		})
+	}
+}
+
+func TestGetByStatus(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	statuses := []ParcelStatus{
+		ParcelStatusRegistered,
+		ParcelStatusSent,
+		ParcelStatusDelivered,
+		ParcelStatusSent,
+		ParcelStatusRegistered,
+		ParcelStatusSent,
+	}
+
+	var sent []Parcel
+	for _, status := range statuses {
+		parcel := getTestParcel()
+		parcel.Status = status
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		parcel.Number = id
+
+		if status == ParcelStatusSent {
+			sent = append(sent, parcel)
+		}
+	}
+
+	storedParcels, err := store.GetByStatus(ParcelStatusSent)
+	require.NoError(t, err)
+	requireParcelsEqual(t, sent, storedParcels)
+
+	storedParcels, err = store.GetByStatus(ParcelStatusReturned)
+	require.NoError(t, err)
+	require.NotNil(t, storedParcels)
+	require.Empty(t, storedParcels)
+}
+
+func TestGetByClientPaged(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+
+	var parcels []Parcel
+	for i := 0; i < 25; i++ {
+		parcel := getTestParcel()
+		parcel.Client = client
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		parcel.Number = id
+
+		parcels = append(parcels, parcel)
+	}
+
+	var paged []Parcel
+	for offset, sizes := 0, []int{10, 10, 5}; offset < len(parcels); offset += 10 {
+		page, err := store.GetByClientPaged(client, 10, offset)
+		require.NoError(t, err)
+		require.Len(t, page, sizes[offset/10])
+
+		paged = append(paged, page...)
+	}
+	requireParcelsEqual(t, parcels, paged)
+
+	page, err := store.GetByClientPaged(client, 10, 30)
+	require.NoError(t, err)
+	require.Empty(t, page)
+
+	all, err := store.GetByClientPaged(client, 0, 0)
+	require.NoError(t, err)
+	requireParcelsEqual(t, parcels, all)
+}
+
+func TestBulkAdd(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcels := make([]Parcel, 1000)
+	for i := range parcels {
+		parcels[i] = getTestParcel()
+	}
+
+	ids, err := store.BulkAdd(parcels)
+	require.NoError(t, err)
+	require.Len(t, ids, len(parcels))
+
+	for i, id := range ids {
+		if i > 0 {
+			require.Equal(t, ids[i-1]+1, id)
+		}
+
+		parcels[i].Number = id
+
+		storedParcel, err := store.Get(id)
+		require.NoError(t, err)
+		requireParcelEqual(t, parcels[i], storedParcel)
+	}
+}
+
+func TestBulkAddRollback(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+	CREATE TRIGGER fail_insert BEFORE INSERT ON parcel
+	WHEN NEW.address = 'fail'
+	BEGIN
+		SELECT RAISE(ABORT, 'insert failed');
+	END;`)
+	require.NoError(t, err)
+
+	store := NewParcelStore(db)
+
+	parcels := []Parcel{getTestParcel(), getTestParcel(), getTestParcel()}
+	parcels[1].Address = "fail"
+
+	ids, err := store.BulkAdd(parcels)
+	require.Error(t, err)
+	require.Nil(t, ids)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM parcel").Scan(&count)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestTxRollback(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	tx, err := store.BeginTx(context.Background())
+	require.NoError(t, err)
+
+	id, err := tx.Add(parcel)
+	require.NoError(t, err)
+
+	err = tx.SetStatus(id, ParcelStatusSent)
+	require.NoError(t, err)
+
+	storedParcel, err := tx.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, storedParcel.Status)
+
+	require.NoError(t, tx.Rollback())
+
+	_, err = store.Get(id)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestTxCommit(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	tx, err := store.BeginTx(context.Background())
+	require.NoError(t, err)
+
+	id, err := tx.Add(parcel)
+	require.NoError(t, err)
+
+	err = tx.SetStatus(id, ParcelStatusSent)
+	require.NoError(t, err)
+
+	require.NoError(t, tx.Commit())
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, storedParcel.Status)
+}
+
+func TestSoftDeleteRestore(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+	parcel.Client = randRange.Intn(10_000_000)
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	parcel.Number = id
+
+	err = store.Delete(id)
+	require.NoError(t, err)
+
+	_, err = store.Get(id)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	storedParcels, err := store.GetByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Empty(t, storedParcels)
+
+	storedParcel, err := store.GetIncludingDeleted(id)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, storedParcel)
+
+	err = store.Restore(id)
+	require.NoError(t, err)
+
+	storedParcel, err = store.Get(id)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, storedParcel)
+
+	err = store.Restore(id)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestUpdatedAt(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	before, err := store.Get(id)
+	require.NoError(t, err)
+	require.NotEmpty(t, before.UpdatedAt)
+
+	err = store.SetStatus(id, ParcelStatusSent)
+	require.NoError(t, err)
+
+	afterStatus, err := store.Get(id)
+	require.NoError(t, err)
+	require.Greater(t, afterStatus.UpdatedAt, before.UpdatedAt)
+
+	_, err = time.Parse(time.RFC3339, afterStatus.UpdatedAt)
+	require.NoError(t, err)
+
+	id, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	before, err = store.Get(id)
+	require.NoError(t, err)
+
+	err = store.SetAddress(id, "new test address")
+	require.NoError(t, err)
+
+	afterAddress, err := store.Get(id)
+	require.NoError(t, err)
+	require.Greater(t, afterAddress.UpdatedAt, before.UpdatedAt)
+}
+
+func TestStatusHistory(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	err = store.SetStatus(id, ParcelStatusSent)
+	require.NoError(t, err)
+
+	err = store.SetStatus(id, ParcelStatusDelivered)
+	require.NoError(t, err)
+
+	err = store.SetStatus(id, ParcelStatusReturned)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	history, err := store.GetStatusHistory(id)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+
+	expected := []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered}
+	for i, event := range history {
+		require.Equal(t, expected[i], event.Status)
+		if i > 0 {
+			require.GreaterOrEqual(t, event.ChangedAt, history[i-1].ChangedAt)
+		}
+	}
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, storedParcel.UpdatedAt, history[2].ChangedAt)
+}
+
+func TestCountByClient(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+	for i := 0; i < 7; i++ {
+		parcel := getTestParcel()
+		parcel.Client = client
+
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
 	}
+
+	_, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	count, err := store.CountByClient(client)
+	require.NoError(t, err)
+	require.Equal(t, 7, count)
+}
+
+func TestCountByClientUnknown(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	_, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	count, err := store.CountByClient(-1)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestAvgDeliveryDuration(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var clock time.Time
+	store := NewParcelStore(db, WithClock(func() time.Time { return clock }))
+
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	from, to := base, base.AddDate(0, 0, 10)
+
+	duration, count, err := store.AvgDeliveryDuration(from, to)
+	require.NoError(t, err)
+	require.Zero(t, duration)
+	require.Zero(t, count)
+
+	deliver := func(createdAt time.Time, after time.Duration) {
+		parcel := getTestParcel()
+		parcel.CreatedAt = createdAt.Format(time.RFC3339)
+
+		clock = createdAt
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+
+		clock = createdAt.Add(after)
+		require.NoError(t, store.SetStatus(id, ParcelStatusDelivered))
+	}
+
+	deliver(base.Add(time.Hour), 24*time.Hour)
+	deliver(base.AddDate(0, 0, 2), 48*time.Hour)
+	deliver(base.AddDate(0, 0, 3), 6*time.Hour)
+
+	// Delivered, but created outside the window.
+	deliver(base.AddDate(0, 0, -1), time.Hour)
+
+	// Created in the window, but not yet delivered.
+	clock = base.AddDate(0, 0, 4)
+	parcel := getTestParcel()
+	parcel.CreatedAt = clock.Format(time.RFC3339)
+	_, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	duration, count, err = store.AvgDeliveryDuration(from, to)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	require.Equal(t, 26*time.Hour, duration)
+}
+
+func TestGetBareRow(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	// Only the columns every version of the schema had.
+	createdAt := "2024-01-02T03:04:05Z"
+	_, err = db.Exec(store.dialect.rebind(`
+	INSERT INTO parcel (client, status, address, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	`), 42, ParcelStatusRegistered, "Main Street 1", createdAt, createdAt)
+	require.NoError(t, err)
+
+	parcels, err := store.GetByClient(42)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+
+	stored, err := store.Get(parcels[0].Number)
+	require.NoError(t, err)
+	require.Equal(t, Parcel{
+		Number:     parcels[0].Number,
+		Client:     42,
+		Status:     ParcelStatusRegistered,
+		Address:    "Main Street 1",
+		AddressRaw: "Main Street 1",
+		CreatedAt:  createdAt,
+		UpdatedAt:  createdAt,
+		Version:    1,
+	}, stored)
+
+	require.NoError(t, store.SetStatus(stored.Number, ParcelStatusSent))
+	require.NoError(t, store.SetStatus(stored.Number, ParcelStatusDelivered))
+
+	stored, err = store.Get(stored.Number)
+	require.NoError(t, err)
+	require.NotNil(t, stored.DeliveredAt)
+}
+
+func TestListClients(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	clients, err := store.ListClients()
+	require.NoError(t, err)
+	require.NotNil(t, clients)
+	require.Empty(t, clients)
+
+	for _, client := range []int{30, 10, 20, 10, 30, 30} {
+		parcel := getTestParcel()
+		parcel.Client = client
+
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+	}
+
+	clients, err = store.ListClients()
+	require.NoError(t, err)
+	require.Equal(t, []int{10, 20, 30}, clients)
+
+	page, err := store.ListClientsPage(0, 2)
+	require.NoError(t, err)
+	require.Equal(t, []int{10, 20}, page)
+
+	page, err = store.ListClientsPage(page[len(page)-1], 2)
+	require.NoError(t, err)
+	require.Equal(t, []int{30}, page)
+
+	_, err = store.ListClientsPage(0, 0)
+	require.ErrorIs(t, err, ErrInvalidPage)
+}
+
+func TestGetAll(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	client := randRange.Intn(10_000_000)
+
+	parcels := []Parcel{
+		{Client: client, Status: ParcelStatusRegistered, Address: "a", CreatedAt: base.Format(time.RFC3339)},
+		{Client: client, Status: ParcelStatusSent, Address: "b", CreatedAt: base.Add(time.Hour).Format(time.RFC3339)},
+		{Client: client + 1, Status: ParcelStatusSent, Address: "c", CreatedAt: base.Add(2 * time.Hour).Format(time.RFC3339)},
+		{Client: client, Status: ParcelStatusSent, Address: "d", CreatedAt: base.Add(3 * time.Hour).Format(time.RFC3339)},
+	}
+	for i := range parcels {
+		id, err := store.Add(parcels[i])
+		require.NoError(t, err)
+		parcels[i].Number = id
+	}
+
+	all, err := store.GetAll(ParcelFilter{}, 0)
+	require.NoError(t, err)
+	requireParcelsEqual(t, parcels, all)
+
+	byClientAndStatus, err := store.GetAll(ParcelFilter{Client: client, Status: ParcelStatusSent}, 0)
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{parcels[1], parcels[3]}, byClientAndStatus)
+
+	byStatusAndDate, err := store.GetAll(ParcelFilter{
+		Status:        ParcelStatusSent,
+		CreatedAfter:  base.Add(30 * time.Minute),
+		CreatedBefore: base.Add(150 * time.Minute),
+	}, 0)
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{parcels[1], parcels[2]}, byStatusAndDate)
+}
+
+func TestGetAllLimit(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	var numbers []int
+	for i := 0; i < 3; i++ {
+		id, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, id)
+	}
+
+	under, err := store.GetAll(ParcelFilter{}, 4)
+	require.NoError(t, err)
+	require.Equal(t, numbers, parcelNumbers(under))
+
+	exact, err := store.GetAll(ParcelFilter{}, 3)
+	require.NoError(t, err)
+	require.Equal(t, numbers, parcelNumbers(exact))
+
+	over, err := store.GetAll(ParcelFilter{}, 2)
+	require.ErrorIs(t, err, ErrTooManyRows)
+	require.Nil(t, over)
+
+	// The limit applies to matching parcels only.
+	sent, err := store.GetAll(ParcelFilter{Status: ParcelStatusSent}, 1)
+	require.NoError(t, err)
+	require.Empty(t, sent)
+
+	_, err = store.GetAll(ParcelFilter{}, -1)
+	require.ErrorIs(t, err, ErrInvalidPage)
+}
+
+func TestUpdate(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	err = store.Update(id, "new test address", ParcelStatusSent)
+	require.NoError(t, err)
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "new test address", storedParcel.Address)
+	require.Equal(t, ParcelStatusSent, storedParcel.Status)
+
+	err = store.Update(id, "", ParcelStatusDelivered)
+	require.NoError(t, err)
+
+	storedParcel, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "new test address", storedParcel.Address)
+	require.Equal(t, ParcelStatusDelivered, storedParcel.Status)
+}
+
+func TestUpdateInvalidStatus(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	err = store.Update(id, "new test address", ParcelStatusDelivered)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, parcel.Address, storedParcel.Address)
+	require.Equal(t, parcel.Status, storedParcel.Status)
+
+	history, err := store.GetStatusHistory(id)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+}
+
+func TestParcelNotFound(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	missing := id + 1
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Get", func() error { _, err := store.Get(missing); return err }},
+		{"GetIncludingDeleted", func() error { _, err := store.GetIncludingDeleted(missing); return err }},
+		{"SetStatus", func() error { return store.SetStatus(missing, ParcelStatusSent) }},
+		{"SetAddress", func() error { return store.SetAddress(missing, "new test address") }},
+		{"Update", func() error { return store.Update(missing, "new test address", ParcelStatusSent) }},
+		{"Delete", func() error { return store.Delete(missing) }},
+		{"Restore", func() error { return store.Restore(missing) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			require.ErrorIs(t, err, ErrParcelNotFound)
+			require.NotErrorIs(t, err, sql.ErrNoRows)
+		})
+	}
+}
+
+func TestSetAddressSameValue(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	err = store.SetAddress(id, parcel.Address)
+	require.NoError(t, err)
+
+	err = store.Update(id, parcel.Address, parcel.Status)
+	require.NoError(t, err)
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, parcel.Address, storedParcel.Address)
+}
+
+func TestSetMissingParcel(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	err = store.SetAddress(-1, "new test address")
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	err = store.SetStatus(-1, ParcelStatusSent)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestDeleteNotAllowed(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	registeredID, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	err = store.Delete(registeredID)
+	require.NoError(t, err)
+
+	_, err = store.Get(registeredID)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	parcel.Status = ParcelStatusSent
+	sentID, err := store.Add(parcel)
+	require.NoError(t, err)
+	parcel.Number = sentID
+
+	err = store.Delete(sentID)
+	require.ErrorIs(t, err, ErrDeleteNotAllowed)
+
+	storedParcel, err := store.Get(sentID)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, storedParcel)
+}
+
+func TestConcurrentWritesRetry(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	// Unlike the shared in-memory database, a file database reports
+	// SQLITE_BUSY straight away when another connection holds the lock.
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "tracker.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, Migrate(db))
+
+	store := NewParcelStore(db, WithRetry(50, time.Millisecond))
+
+	const workers = 5
+	const perWorker = 5
+
+	client := randRange.Intn(10_000_000)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker*3)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < perWorker; j++ {
+				parcel := getTestParcel()
+				parcel.Client = client
+
+				id, err := store.Add(parcel)
+				if err != nil {
+					errs <- err
+					continue
+				}
+
+				errs <- store.SetAddress(id, "new test address")
+				errs <- store.SetStatus(id, ParcelStatusSent)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	parcels, err := store.GetByClient(client)
+	require.NoError(t, err)
+	require.Len(t, parcels, workers*perWorker)
+
+	for _, parcel := range parcels {
+		require.Equal(t, "new test address", parcel.Address)
+		require.Equal(t, ParcelStatusSent, parcel.Status)
+	}
+}
+
+func TestFailedCommitRollsBack(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	path := filepath.Join(t.TempDir(), "tracker.db")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, Migrate(db))
+
+	// With one connection a transaction left open would break every later
+	// write.
+	db.SetMaxOpenConns(1)
+	store := NewParcelStore(db, WithRetry(0, 0))
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// A read transaction on another connection keeps COMMIT from getting
+	// the exclusive lock it needs.
+	reader, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	tx, err := reader.Begin()
+	require.NoError(t, err)
+	var count int
+	require.NoError(t, tx.QueryRow("SELECT COUNT(*) FROM parcel").Scan(&count))
+
+	err = store.SetAddress(id, "new test address")
+	require.True(t, isBusy(err), err)
+	require.NoError(t, tx.Rollback())
+
+	require.NoError(t, store.SetAddress(id, "other test address"))
+
+	p, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "other test address", p.Address)
+}
+
+func TestConcurrentAddWriteLock(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db, WithWriteLock())
+
+	const workers = 20
+	const perWorker = 10
+
+	client := randRange.Intn(10_000_000)
+
+	var wg sync.WaitGroup
+	ids := make(chan int, workers*perWorker)
+	errs := make(chan error, workers*perWorker)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < perWorker; j++ {
+				parcel := getTestParcel()
+				parcel.Client = client
+
+				id, err := store.Add(parcel)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				ids <- id
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	count, err := store.CountByClient(client)
+	require.NoError(t, err)
+	require.Equal(t, workers*perWorker, count)
+
+	var numbers []int
+	for id := range ids {
+		numbers = append(numbers, id)
+	}
+	sort.Ints(numbers)
+
+	for i := range numbers {
+		require.Equal(t, numbers[0]+i, numbers[i])
+	}
+}
+
+func TestWeight(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+	parcel.Weight = 2.5
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	parcel.Number = id
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, storedParcel)
+
+	err = store.SetWeight(id, 3.75)
+	require.NoError(t, err)
+
+	storedParcel, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, 3.75, storedParcel.Weight)
+
+	err = store.SetWeight(id, -1)
+	require.ErrorIs(t, err, ErrInvalidWeight)
+
+	parcel.Weight = -1
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrInvalidWeight)
+
+	storedParcel, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, 3.75, storedParcel.Weight)
+}
+
+func TestWeightDefault(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	_, err = db.Exec(`
+	INSERT INTO parcel (client, status, address, created_at, updated_at)
+	VALUES (1000, 'registered', 'test', '2024-01-10T12:00:00Z', '2024-01-10T12:00:00Z')`)
+	require.NoError(t, err)
+
+	parcels, err := store.GetByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+	require.Zero(t, parcels[0].Weight)
+}
+
+func TestGetByAddressLike(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	addresses := []string{
+		"Baker Street, 221B",
+		"Abbey Road, 3",
+		"baker street, 10",
+		"Discount 50% Lane",
+		"Discount 500 Lane",
+		"under_score Road",
+		"underXscore Road",
+		"Псков, ул. Колотушкина, д. 5",
+	}
+
+	parcels := make([]Parcel, len(addresses))
+	for i, address := range addresses {
+		parcels[i] = getTestParcel()
+		parcels[i].Address = address
+
+		id, err := store.Add(parcels[i])
+		require.NoError(t, err)
+		parcels[i].Number = id
+	}
+
+	found, err := store.GetByAddressLike("BAKER st")
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{parcels[0], parcels[2]}, found)
+
+	found, err = store.GetByAddressLike("50%")
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{parcels[3]}, found)
+
+	found, err = store.GetByAddressLike("under_score")
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{parcels[5]}, found)
+
+	found, err = store.GetByAddressLike("Псков, ул")
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{parcels[7]}, found)
+
+	found, err = store.GetByAddressLike("nowhere")
+	require.NoError(t, err)
+	require.Empty(t, found)
+}
+
+func TestGetByClientSorted(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	// Inserted out of creation order so number and created_at orderings differ.
+	offsets := []time.Duration{2 * time.Hour, 0, time.Hour}
+
+	parcels := make([]Parcel, len(offsets))
+	for i, offset := range offsets {
+		parcels[i] = getTestParcel()
+		parcels[i].Client = client
+		parcels[i].CreatedAt = base.Add(offset).Format(time.RFC3339)
+
+		id, err := store.Add(parcels[i])
+		require.NoError(t, err)
+		parcels[i].Number = id
+	}
+
+	tests := []struct {
+		orderBy  string
+		desc     bool
+		expected []Parcel
+	}{
+		{"number", false, []Parcel{parcels[0], parcels[1], parcels[2]}},
+		{"number", true, []Parcel{parcels[2], parcels[1], parcels[0]}},
+		{"created_at", false, []Parcel{parcels[1], parcels[2], parcels[0]}},
+		{"created_at", true, []Parcel{parcels[0], parcels[2], parcels[1]}},
+	}
+
+	for _, tt := range tests {
+		sorted, err := store.GetByClientSorted(client, tt.orderBy, tt.desc)
+		require.NoError(t, err)
+		requireParcelsEqual(t, tt.expected, sorted)
+	}
+
+	_, err = store.GetByClientSorted(client, "address; DROP TABLE parcel", false)
+	require.ErrorIs(t, err, ErrInvalidSortField)
+}
+
+func TestCountByStatus(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	counts, err := store.CountByStatus()
+	require.NoError(t, err)
+	require.Empty(t, counts)
+
+	expected := map[ParcelStatus]int{
+		ParcelStatusRegistered: 3,
+		ParcelStatusSent:       2,
+		ParcelStatusDelivered:  1,
+	}
+	for status, count := range expected {
+		for i := 0; i < count; i++ {
+			parcel := getTestParcel()
+			parcel.Status = status
+
+			_, err := store.Add(parcel)
+			require.NoError(t, err)
+		}
+	}
+
+	counts, err = store.CountByStatus()
+	require.NoError(t, err)
+	require.Equal(t, expected, counts)
+}
+
+func TestGetByDateRange(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	parcels := make([]Parcel, 5)
+	for i := range parcels {
+		parcels[i] = getTestParcel()
+		parcels[i].CreatedAt = base.Add(time.Duration(i) * time.Hour).Format(time.RFC3339)
+
+		id, err := store.Add(parcels[i])
+		require.NoError(t, err)
+		parcels[i].Number = id
+	}
+
+	found, err := store.GetByDateRange(base.Add(time.Hour), base.Add(3*time.Hour))
+	require.NoError(t, err)
+	requireParcelsEqual(t, parcels[1:4], found)
+
+	// Bounds are compared in UTC regardless of the caller's location.
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	found, err = store.GetByDateRange(base.Add(3*time.Hour).In(loc), time.Time{})
+	require.NoError(t, err)
+	requireParcelsEqual(t, parcels[3:], found)
+}
+
+func TestParcelStatusValues(t *testing.T) {
+	require.Equal(t, "registered", string(ParcelStatusRegistered))
+	require.Equal(t, "sent", string(ParcelStatusSent))
+	require.Equal(t, "delivered", string(ParcelStatusDelivered))
+	require.Equal(t, "returned", string(ParcelStatusReturned))
+
+	require.True(t, ParcelStatusSent.IsValid())
+	require.False(t, ParcelStatus("registred").IsValid())
+	require.False(t, ParcelStatus("").IsValid())
+}
+
+func TestAddInvalidStatus(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	parcel.Status = "registred"
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrInvalidStatus)
+
+	err = store.SetStatus(id, "sentt")
+	require.ErrorIs(t, err, ErrInvalidStatus)
+
+	count, err := store.CountByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestRecipient(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+	parcel.Client = randRange.Intn(10_000_000)
+	parcel.Recipient = "Иван Петров"
+	parcel.Phone = "+79123456789"
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	parcel.Number = id
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, storedParcel)
+
+	storedParcels, err := store.GetByClient(parcel.Client)
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{parcel}, storedParcels)
+
+	err = store.SetRecipient(id, "Пётр Иванов", "89001234567")
+	require.NoError(t, err)
+
+	storedParcel, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "Пётр Иванов", storedParcel.Recipient)
+	require.Equal(t, "89001234567", storedParcel.Phone)
+}
+
+func TestRecipientInvalidPhone(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+	parcel.Phone = "call me maybe"
+
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrInvalidPhone)
+
+	parcel.Phone = ""
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	err = store.SetRecipient(id, "Иван Петров", "+7 912 345")
+	require.ErrorIs(t, err, ErrInvalidPhone)
+
+	storedParcel, err := store.Get(id)
+	require.NoError(t, err)
+	require.Empty(t, storedParcel.Recipient)
+	require.Empty(t, storedParcel.Phone)
+}
+
+func TestGetByClientAfter(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+
+	add := func() int {
+		parcel := getTestParcel()
+		parcel.Client = client
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+
+		return id
+	}
+
+	var expected []int
+	for i := 0; i < 7; i++ {
+		expected = append(expected, add())
+	}
+
+	var seen []int
+	after := 0
+	for {
+		page, err := store.GetByClientAfter(client, after, 3)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		require.LessOrEqual(t, len(page), 3)
+
+		for _, parcel := range page {
+			seen = append(seen, parcel.Number)
+		}
+		after = page[len(page)-1].Number
+
+		// Rows added mid-iteration land after the cursor and are picked up
+		// by a later page.
+		if len(expected) < 10 {
+			expected = append(expected, add())
+		}
+	}
+
+	require.Equal(t, expected, seen)
+}
+
+func TestUpsert(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	p := getTestParcel()
+	p.ExternalCode = "EXT-1"
+
+	id, created, err := store.Upsert(p)
+	require.NoError(t, err)
+	require.True(t, created)
+	require.NotEmpty(t, id)
+
+	p.Address = "updated"
+	p.Status = ParcelStatusSent
+
+	again, created, err := store.Upsert(p)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, id, again)
+
+	p.Number = id
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	requireParcelEqual(t, p, stored)
+
+	parcels, err := store.GetByClient(p.Client)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+
+	history, err := store.GetStatusHistory(id)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+
+	// The address is only changed while the parcel is registered.
+	p.Address = "ignored"
+	_, _, err = store.Upsert(p)
+	require.NoError(t, err)
+
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "updated", stored.Address)
+
+	// A deleted parcel's code isn't taken over.
+	deleted := getTestParcel()
+	deleted.ExternalCode = "EXT-2"
+	deletedID, _, err := store.Upsert(deleted)
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(deletedID))
+
+	_, _, err = store.Upsert(deleted)
+	require.ErrorIs(t, err, ErrDuplicate)
+
+	// Parcels without a code don't conflict with each other.
+	for i := 0; i < 2; i++ {
+		_, err = store.Add(getTestParcel())
+		require.NoError(t, err)
+	}
+
+	_, _, err = store.Upsert(getTestParcel())
+	require.ErrorIs(t, err, ErrMissingExternalCode)
+}
+
+func TestGetStaleInStatus(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, 7, 10, 12, 0, 0, 0, time.UTC)
+	clock := now
+	store := NewParcelStore(db, WithClock(func() time.Time { return clock }))
+
+	sendAt := func(age time.Duration) int {
+		clock = now.Add(-age)
+
+		id, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+
+		return id
+	}
+
+	oldest := sendAt(72 * time.Hour)
+	old := sendAt(49 * time.Hour)
+	sendAt(47 * time.Hour)
+	sendAt(time.Hour)
+
+	// Stale, but delivered since.
+	delivered := sendAt(96 * time.Hour)
+	clock = now.Add(-80 * time.Hour)
+	require.NoError(t, store.SetStatus(delivered, ParcelStatusDelivered))
+
+	clock = now
+	stale, err := store.GetStaleInStatus(ParcelStatusSent, 48*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []int{oldest, old}, parcelNumbers(stale))
+
+	stale, err = store.GetStaleInStatus(ParcelStatusSent, 100*time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, stale)
+	require.Empty(t, stale)
+}
+
+func TestGetByClientAndStatus(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	statuses := []ParcelStatus{
+		ParcelStatusRegistered,
+		ParcelStatusSent,
+		ParcelStatusDelivered,
+		ParcelStatusSent,
+		ParcelStatusRegistered,
+	}
+
+	var sent []Parcel
+	for _, status := range statuses {
+		parcel := getTestParcel()
+		parcel.Status = status
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		parcel.Number = id
+
+		if status == ParcelStatusSent {
+			sent = append(sent, parcel)
+		}
+	}
+
+	other := getTestParcel()
+	other.Client++
+	other.Status = ParcelStatusSent
+	_, err = store.Add(other)
+	require.NoError(t, err)
+
+	storedParcels, err := store.GetByClientAndStatus(sent[0].Client, ParcelStatusSent)
+	require.NoError(t, err)
+	requireParcelsEqual(t, sent, storedParcels)
+
+	storedParcels, err = store.GetByClientAndStatus(sent[0].Client, ParcelStatusReturned)
+	require.NoError(t, err)
+	require.NotNil(t, storedParcels)
+	require.Empty(t, storedParcels)
+}
+
+func TestGetByClientExcludingStatuses(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	statuses := []ParcelStatus{
+		ParcelStatusRegistered,
+		ParcelStatusDelivered,
+		ParcelStatusSent,
+		ParcelStatusReturned,
+		ParcelStatusRegistered,
+	}
+
+	var all, active []Parcel
+	for _, status := range statuses {
+		parcel := getTestParcel()
+		parcel.Status = status
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		parcel.Number = id
+
+		all = append(all, parcel)
+		if status != ParcelStatusDelivered && status != ParcelStatusReturned {
+			active = append(active, parcel)
+		}
+	}
+
+	client := all[0].Client
+
+	storedParcels, err := store.GetByClientExcludingStatuses(client,
+		[]ParcelStatus{ParcelStatusDelivered, ParcelStatusReturned})
+	require.NoError(t, err)
+	requireParcelsEqual(t, active, storedParcels)
+
+	storedParcels, err = store.GetByClientExcludingStatuses(client, nil)
+	require.NoError(t, err)
+	requireParcelsEqual(t, all, storedParcels)
+}
+
+func TestDeleteByClient(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	statuses := []ParcelStatus{
+		ParcelStatusRegistered,
+		ParcelStatusSent,
+		ParcelStatusRegistered,
+		ParcelStatusDelivered,
+		ParcelStatusReturned,
+	}
+
+	var kept []Parcel
+	for _, status := range statuses {
+		parcel := getTestParcel()
+		parcel.Status = status
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		parcel.Number = id
+
+		if status != ParcelStatusRegistered {
+			kept = append(kept, parcel)
+		}
+	}
+
+	other := getTestParcel()
+	other.Client++
+	other.Number, err = store.Add(other)
+	require.NoError(t, err)
+
+	deleted, err := store.DeleteByClient(kept[0].Client, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+
+	count, err := store.CountByClient(kept[0].Client)
+	require.NoError(t, err)
+	require.Equal(t, len(statuses), count)
+
+	deleted, err = store.DeleteByClient(kept[0].Client, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+
+	storedParcels, err := store.GetByClient(kept[0].Client)
+	require.NoError(t, err)
+	requireParcelsEqual(t, kept, storedParcels)
+
+	_, err = store.Get(other.Number)
+	require.NoError(t, err)
+
+	deleted, err = store.DeleteByClient(kept[0].Client, false)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+}
+
+func TestParcelValidate(t *testing.T) {
+	require.NoError(t, getTestParcel().Validate())
+
+	tests := []struct {
+		name   string
+		modify func(p *Parcel)
+		want   error
+	}{
+		{"client", func(p *Parcel) { p.Client = 0 }, ErrInvalidClient},
+		{"address", func(p *Parcel) { p.Address = "  " }, ErrEmptyAddress},
+		{"status", func(p *Parcel) { p.Status = "lost" }, ErrInvalidStatus},
+		{"created_at", func(p *Parcel) { p.CreatedAt = "yesterday" }, ErrInvalidCreatedAt},
+		{"weight", func(p *Parcel) { p.Weight = -1 }, ErrInvalidWeight},
+		{"phone", func(p *Parcel) { p.Phone = "call me" }, ErrInvalidPhone},
+		{"currency", func(p *Parcel) { p.Price = 100; p.Currency = "eur" }, ErrInvalidCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := getTestParcel()
+			tt.modify(&p)
+
+			err := p.Validate()
+			require.ErrorIs(t, err, ErrInvalidParcel)
+			require.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	p := Parcel{Status: ParcelStatusRegistered}
+	err := p.Validate()
+	require.ErrorIs(t, err, ErrInvalidParcel)
+	require.ErrorIs(t, err, ErrInvalidClient)
+	require.ErrorIs(t, err, ErrEmptyAddress)
+	require.ErrorIs(t, err, ErrInvalidCreatedAt)
+	require.NotErrorIs(t, err, ErrInvalidStatus)
+}
+
+func TestAddValidates(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	parcel.Address = ""
+
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrEmptyAddress)
+
+	count, err := store.CountByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestClock(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	fixed := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	store := NewParcelStore(db, WithClock(func() time.Time { return fixed }))
+
+	parcel := getTestParcel()
+	parcel.CreatedAt = ""
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "2024-03-01T12:30:00Z", stored.CreatedAt)
+	require.Equal(t, fixed.Format(timestampLayout), stored.UpdatedAt)
+
+	// A creation time given by the caller is kept.
+	parcel.CreatedAt = "2023-01-02T03:04:05Z"
+
+	id, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, parcel.CreatedAt, stored.CreatedAt)
+}
+
+func TestGetLatestByClient(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	var latest Parcel
+	for _, days := range []int{3, 7, 1, 7, 5} {
+		parcel := getTestParcel()
+		parcel.CreatedAt = base.AddDate(0, 0, days).Format(time.RFC3339)
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		parcel.Number = id
+
+		// Of the two parcels on day 7 the later number wins.
+		if days == 7 {
+			latest = parcel
+		}
+	}
+
+	stored, err := store.GetLatestByClient(latest.Client)
+	require.NoError(t, err)
+	requireParcelEqual(t, latest, stored)
+
+	_, err = store.GetLatestByClient(latest.Client + 1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestExists(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	exists, err := store.Exists(id)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = store.Exists(id + 1)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NoError(t, store.Delete(id))
+
+	exists, err = store.Exists(id)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestDeliveredAt(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	fixed := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	clock := fixed
+	store := NewParcelStore(db, WithClock(func() time.Time { return clock }))
+
+	parcel := getTestParcel()
+	parcel.Status = ParcelStatusSent
+	parcel.ExternalCode = "EXT-1"
+
+	id, _, err := store.Upsert(parcel)
+	require.NoError(t, err)
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Nil(t, stored.DeliveredAt)
+
+	require.NoError(t, store.SetStatus(id, ParcelStatusDelivered))
+
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.NotNil(t, stored.DeliveredAt)
+	require.True(t, fixed.Equal(*stored.DeliveredAt))
+
+	// Later upserts keep the delivery time and can't leave delivered.
+	clock = fixed.Add(time.Hour)
+	parcel.Status = ParcelStatusDelivered
+	_, _, err = store.Upsert(parcel)
+	require.NoError(t, err)
+
+	parcel.Status = ParcelStatusSent
+	_, _, err = store.Upsert(parcel)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, stored.Status)
+	require.NotNil(t, stored.DeliveredAt)
+	require.True(t, fixed.Equal(*stored.DeliveredAt))
+}
+
+func TestGetWithHistory(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	parcel := getTestParcel()
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	parcel.Number = id
+
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+	require.NoError(t, store.SetStatus(id, ParcelStatusDelivered))
+	parcel.Status = ParcelStatusDelivered
+
+	stored, history, err := store.GetWithHistory(id)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, stored)
+
+	require.Len(t, history, 3)
+	expected := []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered}
+	for i, event := range history {
+		require.Equal(t, expected[i], event.Status)
+	}
+	require.Equal(t, stored.UpdatedAt, history[2].ChangedAt)
+
+	_, history, err = store.GetWithHistory(id + 1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+	require.Nil(t, history)
+}
+
+func TestGetByNumbers(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	expected := map[int]Parcel{}
+	for i := 0; i < 3; i++ {
+		parcel := getTestParcel()
+		parcel.Number, err = store.Add(parcel)
+		require.NoError(t, err)
+		expected[parcel.Number] = parcel
+	}
+
+	var numbers []int
+	for number := range expected {
+		numbers = append(numbers, number, number+100)
+	}
+
+	found, err := store.GetByNumbers(numbers)
+	require.NoError(t, err)
+	require.Len(t, found, len(expected))
+	for number, parcel := range expected {
+		requireParcelEqual(t, parcel, found[number])
+	}
+
+	found, err = store.GetByNumbers(nil)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	require.Empty(t, found)
+}
+
+func TestGetByClientInto(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+	var expected []Parcel
+	for i := 0; i < 3; i++ {
+		parcel := getTestParcel()
+		parcel.Client = client
+		parcel.ExternalCode = fmt.Sprintf("INTO-%d-%d", client, i)
+
+		parcel.Number, err = store.Add(parcel)
+		require.NoError(t, err)
+		expected = append(expected, parcel)
+	}
+	require.NoError(t, store.SetStatus(expected[0].Number, ParcelStatusSent))
+	require.NoError(t, store.SetStatus(expected[0].Number, ParcelStatusDelivered))
+	expected[0].Status = ParcelStatusDelivered
+
+	// Leftovers from an earlier use of the slice must not survive.
+	dst := make([]Parcel, 5, 10)
+	backing := &dst[0]
+
+	require.NoError(t, store.GetByClientInto(client, &dst))
+	requireParcelsEqual(t, expected, dst)
+	require.Same(t, backing, &dst[0])
+
+	for i, p := range dst {
+		require.Equal(t, expected[i].ExternalCode, p.ExternalCode)
+	}
+	require.NotNil(t, dst[0].DeliveredAt)
+	require.Nil(t, dst[1].DeliveredAt)
+
+	require.NoError(t, store.GetByClientInto(client+1, &dst))
+	require.Empty(t, dst)
+	require.Equal(t, 10, cap(dst))
+}
+
+func BenchmarkGetByClient(b *testing.B) {
+	db, err := openTestDB(b)
+	require.NoError(b, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	defer store.Close()
+
+	client := randRange.Intn(10_000_000)
+	for i := 0; i < 100; i++ {
+		parcel := getTestParcel()
+		parcel.Client = client
+
+		_, err := store.Add(parcel)
+		require.NoError(b, err)
+	}
+
+	b.Run("GetByClient", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := store.GetByClient(client); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("GetByClientInto", func(b *testing.B) {
+		b.ReportAllocs()
+		var dst []Parcel
+		for i := 0; i < b.N; i++ {
+			if err := store.GetByClientInto(client, &dst); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestGetOpenByClient(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, 8, 20, 12, 0, 0, 0, time.UTC)
+	store := NewParcelStore(db, WithClock(func() time.Time { return now }))
+
+	client := randRange.Intn(10_000_000)
+
+	add := func(status ParcelStatus, age time.Duration) int {
+		parcel := getTestParcel()
+		parcel.Client = client
+		parcel.Status = status
+		parcel.CreatedAt = now.Add(-age).Format(time.RFC3339)
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+
+		return id
+	}
+
+	week := add(ParcelStatusSent, 7*24*time.Hour)
+	almostTwo := add(ParcelStatusRegistered, 47*time.Hour)
+	today := add(ParcelStatusRegistered, time.Hour)
+	add(ParcelStatusDelivered, 3*24*time.Hour)
+	add(ParcelStatusReturned, 5*24*time.Hour)
+
+	open, err := store.GetOpenByClient(client)
+	require.NoError(t, err)
+	require.Len(t, open, 3)
+
+	ages := map[int]int{}
+	for _, p := range open {
+		ages[p.Number] = p.AgeDays
+	}
+	require.Equal(t, map[int]int{week: 7, almostTwo: 1, today: 0}, ages)
+	require.Equal(t, week, open[0].Number)
+	require.Equal(t, ParcelStatusSent, open[0].Status)
+
+	open, err = store.GetOpenByClient(client + 1)
+	require.NoError(t, err)
+	require.NotNil(t, open)
+	require.Empty(t, open)
+}
+
+func TestGetByClients(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+	other := client + 1
+	empty := client + 2
+
+	expected := map[int][]int{client: {}, other: {}, empty: {}}
+	for _, c := range []int{client, other, client, client + 3} {
+		parcel := getTestParcel()
+		parcel.Client = c
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+
+		if _, ok := expected[c]; ok {
+			expected[c] = append(expected[c], id)
+		}
+	}
+
+	found, err := store.GetByClients([]int{client, other, empty})
+	require.NoError(t, err)
+	require.Len(t, found, len(expected))
+	for c, numbers := range expected {
+		require.Contains(t, found, c)
+		require.Equal(t, numbers, parcelNumbers(found[c]))
+	}
+	require.NotNil(t, found[empty])
+
+	found, err = store.GetByClients(nil)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	require.Empty(t, found)
+}
+
+func TestGetByClientsDeduped(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	client := randRange.Intn(10_000_000)
+	merged := client + 1
+
+	var expected []int
+	for _, c := range []int{client, merged, client, client + 2, merged} {
+		parcel := getTestParcel()
+		parcel.Client = c
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+
+		if c != client+2 {
+			expected = append(expected, id)
+		}
+	}
+
+	found, err := store.GetByClientsDeduped([]int{merged, client, merged, client})
+	require.NoError(t, err)
+	require.Equal(t, expected, parcelNumbers(found))
+
+	found, err = store.GetByClientsDeduped(nil)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	require.Empty(t, found)
+}
+
+func TestPrice(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	prices := []struct {
+		price    int64
+		currency string
+	}{
+		{1250, "EUR"},
+		{300, "USD"},
+		{750, "EUR"},
+		{0, ""},
+	}
+
+	for _, price := range prices {
+		parcel := getTestParcel()
+		parcel.Price = price.price
+		parcel.Currency = price.currency
+
+		parcel.Number, err = store.Add(parcel)
+		require.NoError(t, err)
+
+		stored, err := store.Get(parcel.Number)
+		require.NoError(t, err)
+		requireParcelEqual(t, parcel, stored)
+	}
+
+	other := getTestParcel()
+	other.Client++
+	other.Price = 999
+	other.Currency = "EUR"
+	_, err = store.Add(other)
+	require.NoError(t, err)
+
+	totals, err := store.SumPriceByClient(getTestParcel().Client)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"EUR": 2000, "USD": 300}, totals)
+
+	parcel := getTestParcel()
+	parcel.Price = 100
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrInvalidCurrency)
+}
+
+func TestSetAddressVersioned(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// Both operators load the parcel before either saves.
+	first, err := store.Get(id)
+	require.NoError(t, err)
+	second, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, 1, first.Version)
+
+	require.NoError(t, store.SetAddressVersioned(id, "first", first.Version))
+
+	err = store.SetAddressVersioned(id, "second", second.Version)
+	require.ErrorIs(t, err, ErrVersionConflict)
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "first", stored.Address)
+	require.Equal(t, 2, stored.Version)
+
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, 3, stored.Version)
+
+	err = store.SetAddressVersioned(id+1, "missing", 1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestGetByClientFunc(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	var total float64
+	for i := 1; i <= 4; i++ {
+		parcel := getTestParcel()
+		parcel.Weight = float64(i)
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+
+		total += parcel.Weight
+	}
+
+	var sum float64
+	var seen int
+	err = store.GetByClientFunc(getTestParcel().Client, func(p Parcel) error {
+		sum += p.Weight
+		seen++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, seen)
+	require.Equal(t, total, sum)
+
+	stop := errors.New("stop")
+	seen = 0
+	err = store.GetByClientFunc(getTestParcel().Client, func(p Parcel) error {
+		seen++
+		if seen == 2 {
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 2, seen)
+
+	// The rows were closed and their connection returned to the pool.
+	require.Zero(t, db.Stats().InUse)
+}
+
+func TestWithTx(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	ctx := context.Background()
+
+	var committed int
+	err = store.WithTx(ctx, func(tx *ParcelStore) error {
+		var err error
+		committed, err = tx.Add(getTestParcel())
+		if err != nil {
+			return err
+		}
+
+		return tx.SetStatus(committed, ParcelStatusSent)
+	})
+	require.NoError(t, err)
+
+	stored, err := store.Get(committed)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, stored.Status)
+
+	failed := errors.New("failed")
+	var rolledBack int
+	err = store.WithTx(ctx, func(tx *ParcelStore) error {
+		var err error
+		rolledBack, err = tx.Add(getTestParcel())
+		require.NoError(t, err)
+
+		return failed
+	})
+	require.ErrorIs(t, err, failed)
+
+	_, err = store.Get(rolledBack)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	var panicked int
+	require.PanicsWithValue(t, "boom", func() {
+		store.WithTx(ctx, func(tx *ParcelStore) error {
+			var err error
+			panicked, err = tx.Add(getTestParcel())
+			require.NoError(t, err)
+
+			panic("boom")
+		})
+	})
+
+	_, err = store.Get(panicked)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	count, err := store.CountByClient(getTestParcel().Client)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestAddFull(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	fixed := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	store := NewParcelStore(db, WithClock(func() time.Time { return fixed }))
+
+	parcel := getTestParcel()
+	parcel.CreatedAt = ""
+
+	added, err := store.AddFull(parcel)
+	require.NoError(t, err)
+	require.NotZero(t, added.Number)
+	require.Equal(t, "2024-03-01T12:30:00Z", added.CreatedAt)
+	require.Equal(t, 1, added.Version)
+
+	stored, err := store.Get(added.Number)
+	require.NoError(t, err)
+	require.Equal(t, stored, added)
+}
+
+func TestNewParcelStoreOptions(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	require.Equal(t, defaultTable, store.table)
+	require.Equal(t, defaultRetries, store.retries)
+	require.Equal(t, defaultRetryDelay, store.retryDelay)
+	require.Equal(t, time.RFC3339, store.timeLayout())
+	require.Equal(t, time.UTC, store.timeLocation())
+	require.NotNil(t, store.stmts)
+	require.Nil(t, store.observer)
+	require.Nil(t, store.logger)
+
+	_, err = db.Exec("DROP TABLE IF EXISTS parcel_options")
+	require.NoError(t, err)
+	require.NoError(t, MigrateTable(db, "parcel_options"))
+
+	clock := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	observer := &fakeObserver{}
+	logger := &fakeQueryLogger{}
+
+	store = NewParcelStore(db,
+		WithTable("parcel_options"),
+		WithClock(func() time.Time { return clock }),
+		WithRetry(5, time.Millisecond),
+		WithObserver(observer),
+		WithQueryLogger(logger),
+	)
+	require.Equal(t, 5, store.retries)
+	require.Equal(t, time.Millisecond, store.retryDelay)
+
+	parcel := getTestParcel()
+	parcel.CreatedAt = ""
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, clock.Format(time.RFC3339), stored.CreatedAt)
+
+	require.NotEmpty(t, observer.observed)
+	require.Equal(t, "Add", observer.observed[0].op)
+
+	require.NotEmpty(t, logger.logged)
+	for _, q := range logger.logged {
+		require.Contains(t, q.query, "parcel_options")
+	}
+
+	_, err = NewParcelStore(db).Get(id)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestOpenFileStore(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	path := filepath.Join(t.TempDir(), "tracker.db")
+
+	store, err := OpenFileStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	parcel := getTestParcel()
+	parcel.Number, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	stored, err := store.Get(parcel.Number)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, stored)
+
+	var mode string
+	require.NoError(t, store.db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	require.Equal(t, "wal", mode)
+
+	require.FileExists(t, path)
+	require.FileExists(t, path+"-wal")
+}
+
+func TestPoolOptions(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "tracker.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	NewParcelStore(db,
+		WithMaxOpenConns(3),
+		WithMaxIdleConns(1),
+		WithConnMaxLifetime(time.Hour),
+	)
+
+	require.Equal(t, 3, db.Stats().MaxOpenConnections)
+
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := db.Conn(ctx)
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	require.Equal(t, 3, db.Stats().OpenConnections)
+
+	for _, conn := range conns {
+		require.NoError(t, conn.Close())
+	}
+
+	stats := db.Stats()
+	require.Equal(t, 1, stats.Idle)
+	require.Equal(t, int64(2), stats.MaxIdleClosed)
+
+	// Writes run in a transaction holding the only connection, so nothing
+	// they do may need another one.
+	require.NoError(t, Migrate(db))
+	store := NewParcelStore(db, WithMaxOpenConns(1))
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	id, err := store.AddContext(ctx, getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatusContext(ctx, id, ParcelStatusSent))
+	require.NoError(t, store.SetAddressContext(ctx, id, "new test address"))
+
+	_, err = store.BulkAddContext(ctx, []Parcel{getTestParcel(), getTestParcel()})
+	require.NoError(t, err)
+
+	stored, err := store.GetContext(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, stored.Status)
+}
+
+func TestGetStatusCountsByClient(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	expected := map[ParcelStatus]int{
+		ParcelStatusRegistered: 2,
+		ParcelStatusSent:       1,
+		ParcelStatusDelivered:  3,
+	}
+	for status, count := range expected {
+		for i := 0; i < count; i++ {
+			parcel := getTestParcel()
+			parcel.Status = status
+
+			_, err := store.Add(parcel)
+			require.NoError(t, err)
+		}
+	}
+
+	other := getTestParcel()
+	other.Client++
+	other.Status = ParcelStatusReturned
+	_, err = store.Add(other)
+	require.NoError(t, err)
+
+	counts, err := store.GetStatusCountsByClient(getTestParcel().Client)
+	require.NoError(t, err)
+	require.Equal(t, expected, counts)
+
+	counts, err = store.GetStatusCountsByClient(other.Client + 1)
+	require.NoError(t, err)
+	require.NotNil(t, counts)
+	require.Empty(t, counts)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewParcelStore(db, WithClock(func() time.Time { return clock }))
+
+	cutoff := clock.AddDate(0, 0, 10)
+
+	var old, recent []int
+	for _, days := range []int{1, 5, 9, 11, 20} {
+		id, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+
+		clock = time.Date(2024, 1, 1+days, 0, 0, 0, 0, time.UTC)
+		require.NoError(t, store.Delete(id))
+
+		if days < 10 {
+			old = append(old, id)
+		} else {
+			recent = append(recent, id)
+		}
+	}
+
+	live, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	purged, err := store.PurgeDeleted(cutoff)
+	require.NoError(t, err)
+	require.Equal(t, len(old), purged)
+
+	for _, id := range old {
+		_, err := store.GetIncludingDeleted(id)
+		require.ErrorIs(t, err, ErrParcelNotFound)
+
+		history, err := store.GetStatusHistory(id)
+		require.NoError(t, err)
+		require.Empty(t, history)
+	}
+
+	for _, id := range recent {
+		_, err := store.GetIncludingDeleted(id)
+		require.NoError(t, err)
+	}
+
+	_, err = store.Get(live)
+	require.NoError(t, err)
+}
+
+func TestAddIfNotExists(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	parcel.CreatedAt = "2024-02-01T09:00:00Z"
+
+	id, existed, err := store.AddIfNotExists(parcel)
+	require.NoError(t, err)
+	require.False(t, existed)
+
+	// Later the same day counts as a duplicate.
+	parcel.CreatedAt = "2024-02-01T18:30:00Z"
+
+	again, existed, err := store.AddIfNotExists(parcel)
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, id, again)
+
+	count, err := store.CountByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// Another day or another address is a new parcel.
+	parcel.CreatedAt = "2024-02-02T09:00:00Z"
+	_, existed, err = store.AddIfNotExists(parcel)
+	require.NoError(t, err)
+	require.False(t, existed)
+
+	parcel.Address = "elsewhere"
+	_, existed, err = store.AddIfNotExists(parcel)
+	require.NoError(t, err)
+	require.False(t, existed)
+
+	// Plain Add still allows duplicates.
+	_, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	count, err = store.CountByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+}
+
+func TestCountCreatedToday(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Already the 11th in UTC+3, still the 10th in UTC.
+	now := time.Date(2024, 3, 10, 22, 30, 0, 0, time.UTC)
+	store := NewParcelStore(db, WithClock(func() time.Time { return now }))
+
+	for _, createdAt := range []string{
+		"2024-03-10T20:59:59Z", // 23:59:59 on the 10th in UTC+3
+		"2024-03-10T21:30:00Z",
+		"2024-03-10T22:00:00Z",
+		"2024-03-09T23:00:00Z",
+	} {
+		parcel := getTestParcel()
+		parcel.CreatedAt = createdAt
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+	}
+
+	count, err := store.CountCreatedToday(time.FixedZone("UTC+3", 3*60*60))
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	count, err = store.CountCreatedToday(time.UTC)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+}
+
+func TestBulkTransition(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	cutoff := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	add := func(status ParcelStatus, createdAt time.Time) int {
+		parcel := getTestParcel()
+		parcel.Status = status
+		parcel.CreatedAt = createdAt.Format(time.RFC3339)
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+
+		return id
+	}
+
+	oldSent := []int{
+		add(ParcelStatusSent, cutoff.AddDate(0, 0, -10)),
+		add(ParcelStatusSent, cutoff.Add(-time.Second)),
+	}
+	newSent := add(ParcelStatusSent, cutoff)
+	oldRegistered := add(ParcelStatusRegistered, cutoff.AddDate(0, 0, -10))
+
+	count, err := store.BulkTransition(ParcelStatusSent, ParcelStatusDelivered, cutoff)
+	require.NoError(t, err)
+	require.Equal(t, len(oldSent), count)
+
+	for _, id := range oldSent {
+		stored, err := store.Get(id)
+		require.NoError(t, err)
+		require.Equal(t, ParcelStatusDelivered, stored.Status)
+		require.NotNil(t, stored.DeliveredAt)
+
+		history, err := store.GetStatusHistory(id)
+		require.NoError(t, err)
+		require.Equal(t, ParcelStatusDelivered, history[len(history)-1].Status)
+	}
+
+	for id, status := range map[int]ParcelStatus{newSent: ParcelStatusSent, oldRegistered: ParcelStatusRegistered} {
+		stored, err := store.Get(id)
+		require.NoError(t, err)
+		require.Equal(t, status, stored.Status)
+	}
+
+	count, err = store.BulkTransition(ParcelStatusSent, ParcelStatusDelivered, cutoff)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	_, err = store.BulkTransition(ParcelStatusRegistered, ParcelStatusDelivered, cutoff)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	_, err = store.BulkTransition(ParcelStatusSent, "lost", cutoff)
+	require.ErrorIs(t, err, ErrInvalidStatus)
+
+	stored, err := store.Get(oldRegistered)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusRegistered, stored.Status)
+}
+
+func TestSetStatusBatch(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	var numbers []int
+	for i := 0; i < 3; i++ {
+		id, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, id)
+	}
+
+	updated, err := store.SetStatusBatch(numbers, ParcelStatusSent)
+	require.NoError(t, err)
+	require.Equal(t, 3, updated)
+
+	for _, number := range numbers {
+		stored, err := store.Get(number)
+		require.NoError(t, err)
+		require.Equal(t, ParcelStatusSent, stored.Status)
+	}
+
+	registered, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// The registered parcel can't be delivered, so the sent ones stay sent.
+	batch := append([]int{registered}, numbers...)
+	batch[0], batch[len(batch)-1] = batch[len(batch)-1], batch[0]
+
+	updated, err = store.SetStatusBatch(batch, ParcelStatusDelivered)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+	require.Zero(t, updated)
+
+	for _, number := range numbers {
+		stored, err := store.Get(number)
+		require.NoError(t, err)
+		require.Equal(t, ParcelStatusSent, stored.Status)
+
+		history, err := store.GetStatusHistory(number)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+	}
+}
+
+func TestGetClientSummaries(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	for _, status := range []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered} {
+		parcel := getTestParcel()
+		parcel.Status = status
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+	}
+
+	client := getTestParcel().Client
+
+	parcels, err := store.GetByClientSorted(client, "number", false)
+	require.NoError(t, err)
+
+	summaries, err := store.GetClientSummaries(client)
+	require.NoError(t, err)
+	require.Len(t, summaries, len(parcels))
+	for i, p := range parcels {
+		require.Equal(t, ParcelSummary{Number: p.Number, Status: p.Status}, summaries[i])
+	}
+
+	rows, err := db.Query(clientSummariesQuery, client)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	require.Equal(t, []string{"number", "status"}, columns)
+}
+
+func TestReturn(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+
+	reason := "recipient not at home"
+	require.NoError(t, store.Return(id, reason))
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusReturned, stored.Status)
+	require.Equal(t, reason, stored.ReturnReason)
+
+	history, err := store.GetStatusHistory(id)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, ParcelStatusReturned, history[2].Status)
+	require.Equal(t, reason, history[2].Reason)
+	require.Empty(t, history[1].Reason)
+
+	// A returned parcel can't be returned again.
+	err = store.Return(id, reason)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	delivered := getTestParcel()
+	delivered.Status = ParcelStatusDelivered
+	id, err = store.Add(delivered)
+	require.NoError(t, err)
+
+	err = store.Return(id, reason)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	err = store.Return(-1, reason)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestRequeue(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+	require.NoError(t, store.Return(id, "wrong address"))
+
+	require.NoError(t, store.Requeue(id))
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusRegistered, stored.Status)
+	require.Empty(t, stored.ReturnReason)
+
+	history, err := store.GetStatusHistory(id)
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	require.Equal(t, ParcelStatusReturned, history[2].Status)
+	require.Equal(t, "wrong address", history[2].Reason)
+	require.Equal(t, ParcelStatusRegistered, history[3].Status)
+
+	// The requeued parcel can be sent again.
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+
+	err = store.Requeue(id)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	registered, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	err = store.Requeue(registered)
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	err = store.Requeue(-1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestGetByClientPage(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	var numbers []int
+	for i := 0; i < 5; i++ {
+		id, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, id)
+	}
+
+	other := getTestParcel()
+	other.Client++
+	_, err = store.Add(other)
+	require.NoError(t, err)
+
+	client := getTestParcel().Client
+	want := [][]int{numbers[0:2], numbers[2:4], numbers[4:5], {}}
+
+	for i, wantNumbers := range want {
+		page, err := store.GetByClientPage(client, i+1, 2)
+		require.NoError(t, err)
+		require.Equal(t, len(numbers), page.Total)
+		require.Equal(t, i+1, page.Page)
+		require.Equal(t, 2, page.PageSize)
+		require.Equal(t, wantNumbers, parcelNumbers(page.Items))
+	}
+
+	_, err = store.GetByClientPage(client, 0, 2)
+	require.ErrorIs(t, err, ErrInvalidPage)
+
+	_, err = store.GetByClientPage(client, 1, 0)
+	require.ErrorIs(t, err, ErrInvalidPage)
+}
+
+func TestGetOldestRegistered(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	_, err = store.GetOldestRegistered()
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	var oldest Parcel
+	for _, tc := range []struct {
+		days   int
+		status ParcelStatus
+	}{
+		{3, ParcelStatusRegistered},
+		{1, ParcelStatusSent},
+		{2, ParcelStatusRegistered},
+		{0, ParcelStatusDelivered},
+		{5, ParcelStatusRegistered},
+	} {
+		parcel := getTestParcel()
+		parcel.Status = tc.status
+		parcel.CreatedAt = base.AddDate(0, 0, tc.days).Format(time.RFC3339)
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		parcel.Number = id
+
+		if tc.days == 2 {
+			oldest = parcel
+		}
+	}
+
+	stored, err := store.GetOldestRegistered()
+	require.NoError(t, err)
+	requireParcelEqual(t, oldest, stored)
+}
+
+func TestAddDuplicateExternalCode(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	parcel.ExternalCode = "EXT-1"
+
+	_, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrDuplicate)
+
+	_, err = store.BulkAdd([]Parcel{getTestParcel(), parcel})
+	require.ErrorIs(t, err, ErrDuplicate)
+
+	// Other failures aren't reported as duplicates.
+	parcel.ExternalCode = "EXT-2"
+	parcel.Client = 0
+	_, err = store.Add(parcel)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrDuplicate)
+}
+
+func TestGetByClientChangedSince(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := NewParcelStore(db, WithClock(func() time.Time { return now }))
+
+	var numbers []int
+	for i := 0; i < 4; i++ {
+		id, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, id)
+	}
+
+	other := getTestParcel()
+	other.Client++
+	otherID, err := store.Add(other)
+	require.NoError(t, err)
+
+	cutoff := now
+
+	now = now.Add(time.Minute)
+	require.NoError(t, store.SetStatus(numbers[2], ParcelStatusSent))
+	require.NoError(t, store.SetStatus(otherID, ParcelStatusSent))
+
+	now = now.Add(time.Minute)
+	require.NoError(t, store.SetAddress(numbers[0], "new test address"))
+
+	changed, err := store.GetByClientChangedSince(getTestParcel().Client, cutoff)
+	require.NoError(t, err)
+	require.Equal(t, []int{numbers[2], numbers[0]}, parcelNumbers(changed))
+
+	// The last UpdatedAt works as the next watermark.
+	watermark, err := time.Parse(time.RFC3339Nano, changed[1].UpdatedAt)
+	require.NoError(t, err)
+
+	changed, err = store.GetByClientChangedSince(getTestParcel().Client, watermark)
+	require.NoError(t, err)
+	require.Empty(t, changed)
+}
+
+func TestDuplicate(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := NewParcelStore(db, WithClock(func() time.Time { return now }))
+
+	parcel := getTestParcel()
+	parcel.Status = ParcelStatusDelivered
+	parcel.CreatedAt = now.AddDate(0, 0, -7).Format(time.RFC3339)
+	parcel.ExternalCode = "EXT-1"
+	parcel.Recipient = "Ivan"
+	parcel.Weight = 2.5
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	original, err := store.Get(id)
+	require.NoError(t, err)
+
+	now = now.Add(time.Hour)
+
+	copyID, err := store.Duplicate(id)
+	require.NoError(t, err)
+	require.NotEqual(t, id, copyID)
+
+	duplicate, err := store.Get(copyID)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusRegistered, duplicate.Status)
+	require.Equal(t, now.Format(time.RFC3339), duplicate.CreatedAt)
+	require.Nil(t, duplicate.DeliveredAt)
+	require.Empty(t, duplicate.ExternalCode)
+	require.Equal(t, original.Client, duplicate.Client)
+	require.Equal(t, original.Address, duplicate.Address)
+	require.Equal(t, original.Recipient, duplicate.Recipient)
+	require.Equal(t, original.Weight, duplicate.Weight)
+
+	unchanged, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, original, unchanged)
+
+	_, err = store.Duplicate(copyID + 1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestGetByStatusByPriority(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	base := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	add := func(priority int, hours int) int {
+		parcel := getTestParcel()
+		parcel.Status = ParcelStatusSent
+		parcel.Priority = priority
+		parcel.CreatedAt = base.Add(time.Duration(hours) * time.Hour).Format(time.RFC3339)
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+
+		return id
+	}
+
+	newNormal := add(0, 5)
+	oldNormal := add(0, 1)
+	newExpress := add(2, 4)
+	oldExpress := add(2, 2)
+	urgent := add(5, 6)
+	later := add(0, 3)
+
+	registered := getTestParcel()
+	registered.Priority = 9
+	_, err = store.Add(registered)
+	require.NoError(t, err)
+
+	parcels, err := store.GetByStatusByPriority(ParcelStatusSent)
+	require.NoError(t, err)
+	require.Equal(t, []int{urgent, oldExpress, newExpress, oldNormal, later, newNormal}, parcelNumbers(parcels))
+	require.Equal(t, 5, parcels[0].Priority)
+
+	require.NoError(t, store.SetPriority(later, 3))
+
+	parcels, err = store.GetByStatusByPriority(ParcelStatusSent)
+	require.NoError(t, err)
+	require.Equal(t, []int{urgent, later, oldExpress, newExpress, oldNormal, newNormal}, parcelNumbers(parcels))
+
+	stored, err := store.Get(later)
+	require.NoError(t, err)
+	require.Equal(t, 3, stored.Priority)
+
+	require.ErrorIs(t, store.SetPriority(-1, 1), ErrParcelNotFound)
+}
+
+func TestGetByStatusPage(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// Sent parcels are added out of creation order.
+	sent := map[int]int{}
+	for _, days := range []int{4, 1, 3, 0, 2} {
+		parcel := getTestParcel()
+		parcel.Status = ParcelStatusSent
+		parcel.CreatedAt = base.AddDate(0, 0, days).Format(time.RFC3339)
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		sent[days] = id
+	}
+
+	registered := getTestParcel()
+	registered.CreatedAt = base.AddDate(0, 0, -1).Format(time.RFC3339)
+	_, err = store.Add(registered)
+	require.NoError(t, err)
+
+	want := [][]int{{sent[0], sent[1]}, {sent[2], sent[3]}, {sent[4]}}
+
+	for i, wantNumbers := range want {
+		page, err := store.GetByStatusPage(ParcelStatusSent, i+1, 2)
+		require.NoError(t, err)
+		require.Equal(t, len(sent), page.Total)
+		require.Equal(t, i+1, page.Page)
+		require.Equal(t, 2, page.PageSize)
+		require.Equal(t, wantNumbers, parcelNumbers(page.Items))
+	}
+
+	_, err = store.GetByStatusPage(ParcelStatusSent, 1, 0)
+	require.ErrorIs(t, err, ErrInvalidPage)
+}
+
+func TestAddressNormalization(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	parcel := getTestParcel()
+	parcel.Address = "  Moscow,   Tverskaya\tst.  1 "
+
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "Moscow, Tverskaya st. 1", stored.Address)
+	require.Equal(t, parcel.Address, stored.AddressRaw)
+
+	raw := " Pskov,  Pushkina   5\n"
+	require.NoError(t, store.SetAddress(id, raw))
+
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "Pskov, Pushkina 5", stored.Address)
+	require.Equal(t, raw, stored.AddressRaw)
+
+	// Differently spaced addresses count as the same one.
+	parcel.Address = "Pskov, Pushkina 5"
+	existing, existed, err := store.AddIfNotExists(parcel)
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, id, existing)
+
+	// Keeping the address in Update keeps its raw form too.
+	require.NoError(t, store.Update(id, "", ParcelStatusSent))
+
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "Pskov, Pushkina 5", stored.Address)
+	require.Equal(t, raw, stored.AddressRaw)
+}
+
+func TestCountsByDay(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	for _, createdAt := range []string{
+		"2024-04-30T23:59:59Z",
+		"2024-05-01T00:00:00Z",
+		"2024-05-01T12:00:00Z",
+		// 2024-05-01 in UTC, although a day later locally.
+		"2024-05-02T01:00:00+03:00",
+		"2024-05-03T08:00:00Z",
+		"2024-05-04T00:00:00Z",
+	} {
+		parcel := getTestParcel()
+		parcel.CreatedAt = createdAt
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+	}
+
+	counts, err := store.CountsByDay(
+		time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"2024-05-01": 3, "2024-05-03": 1}, counts)
+
+	// The range is taken in UTC too.
+	moscow := time.FixedZone("MSK", 3*60*60)
+	counts, err = store.CountsByDay(
+		time.Date(2024, 5, 4, 2, 0, 0, 0, moscow),
+		time.Date(2024, 5, 4, 2, 0, 0, 0, moscow))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"2024-05-03": 1}, counts)
+}
+
+func TestSetStatusBy(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	require.NoError(t, store.SetStatusBy(id, ParcelStatusSent, "courier-17"))
+	require.NoError(t, store.SetStatusBy(id, ParcelStatusDelivered, ""))
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, stored.Status)
+
+	history, err := store.GetStatusHistory(id)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, SystemActor, history[0].Actor)
+	require.Equal(t, "courier-17", history[1].Actor)
+	require.Equal(t, SystemActor, history[2].Actor)
+
+	other, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(other, ParcelStatusSent))
+
+	history, err = store.GetStatusHistory(other)
+	require.NoError(t, err)
+	require.Equal(t, SystemActor, history[1].Actor)
+
+	err = store.SetStatusBy(id, ParcelStatusSent, "courier-17")
+	require.ErrorIs(t, err, ErrInvalidStatusTransition)
+}
+
+func TestWithTimeFormat(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	msk := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2024, 5, 1, 21, 30, 0, 0, time.UTC)
+	store := NewParcelStore(db,
+		WithTimeFormat("2006-01-02 15:04:05", msk),
+		WithClock(func() time.Time { return now }))
+
+	stamped, err := store.Add(getTestParcelCreatedAt(""))
+	require.NoError(t, err)
+	given, err := store.Add(getTestParcelCreatedAt("2024-05-01 09:00:00"))
+	require.NoError(t, err)
+
+	p, err := store.Get(stamped)
+	require.NoError(t, err)
+	require.Equal(t, "2024-05-02 00:30:00", p.CreatedAt)
+
+	p, err = store.Get(given)
+	require.NoError(t, err)
+	require.Equal(t, "2024-05-01 09:00:00", p.CreatedAt)
+
+	_, err = store.Add(getTestParcelCreatedAt("yesterday"))
+	require.ErrorIs(t, err, ErrInvalidCreatedAt)
+
+	// Range bounds are formatted in the store's location.
+	parcels, err := store.GetByDateRange(
+		time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, []int{given}, parcelNumbers(parcels))
+
+	count, err := store.CountCreatedToday(msk)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// With RFC3339 given times are rewritten in the store's location.
+	store = NewParcelStore(db, WithTimeFormat(time.RFC3339, msk))
+	id, err := store.Add(getTestParcelCreatedAt("2024-05-01T10:00:00Z"))
+	require.NoError(t, err)
+
+	p, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "2024-05-01T13:00:00+03:00", p.CreatedAt)
+
+	// RFC3339 given times are accepted in any layout.
+	store = NewParcelStore(db, WithTimeFormat("2006-01-02 15:04:05", msk))
+	id, err = store.Add(getTestParcelCreatedAt("2024-05-01T10:00:00Z"))
+	require.NoError(t, err)
+
+	p, err = store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "2024-05-01 13:00:00", p.CreatedAt)
+}
+
+func getTestParcelCreatedAt(createdAt string) Parcel {
+	p := getTestParcel()
+	p.CreatedAt = createdAt
+
+	return p
+}
+
+func TestGetByClientGrouped(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	want := map[ParcelStatus][]int{}
+
+	// Parcels are added out of creation order.
+	for _, tc := range []struct {
+		days   int
+		status ParcelStatus
+	}{
+		{3, ParcelStatusSent},
+		{1, ParcelStatusRegistered},
+		{0, ParcelStatusSent},
+		{2, ParcelStatusRegistered},
+		{4, ParcelStatusDelivered},
+		{1, ParcelStatusSent},
+	} {
+		parcel := getTestParcelCreatedAt(base.AddDate(0, 0, tc.days).Format(time.RFC3339))
+		parcel.Status = tc.status
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		want[tc.status] = append(want[tc.status], id)
+	}
+
+	other := getTestParcel()
+	other.Client++
+	_, err = store.Add(other)
+	require.NoError(t, err)
+
+	groups, err := store.GetByClientGrouped(getTestParcel().Client)
+	require.NoError(t, err)
+	require.Len(t, groups, 3)
+	require.NotContains(t, groups, ParcelStatusReturned)
+
+	// By creation time: days 0, 1, 3 for sent and 1, 2 for registered.
+	require.Equal(t, []int{want[ParcelStatusSent][1], want[ParcelStatusSent][2], want[ParcelStatusSent][0]},
+		parcelNumbers(groups[ParcelStatusSent]))
+	require.Equal(t, want[ParcelStatusRegistered], parcelNumbers(groups[ParcelStatusRegistered]))
+	require.Equal(t, want[ParcelStatusDelivered], parcelNumbers(groups[ParcelStatusDelivered]))
 }
@@ -0,0 +1,17 @@
+//go:build postgres
+
+package main
+
+import (
+	"os"
+
+	_ "github.com/lib/pq"
+)
+
+// Run the suite against Postgres with:
+//
+//	PARCEL_POSTGRES_DSN=postgres://... go test -tags postgres ./...
+func init() {
+	testDriver = "postgres"
+	testDSN = os.Getenv("PARCEL_POSTGRES_DSN")
+}
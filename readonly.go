@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ParcelReader is the part of the store that only reads.
+type ParcelReader interface {
+	Get(number int) (Parcel, error)
+	GetByNumbers(numbers []int) (map[int]Parcel, error)
+	GetByClient(client int) ([]Parcel, error)
+	GetByClientPage(client, page, size int) (ParcelPage, error)
+	GetByStatus(status ParcelStatus) ([]Parcel, error)
+	GetByStatusPage(status ParcelStatus, page, size int) (ParcelPage, error)
+	GetByClientAndStatus(client int, status ParcelStatus) ([]Parcel, error)
+	GetByDateRange(from, to time.Time) ([]Parcel, error)
+	GetStatusHistory(number int) ([]StatusEvent, error)
+}
+
+var (
+	_ ParcelReader = ParcelStore{}
+	_ ParcelReader = ReadOnlyStore{}
+)
+
+// ReadOnlyStore is a store without any methods that write, for code that
+// must not change parcels.
+type ReadOnlyStore struct {
+	store ParcelStore
+}
+
+// NewReadOnlyStore takes the same options as NewParcelStore. To have the
+// database refuse writes as well, open db with OpenReadOnly.
+func NewReadOnlyStore(db *sql.DB, opts ...Option) ReadOnlyStore {
+	return ReadOnlyStore{store: NewParcelStore(db, opts...)}
+}
+
+// OpenReadOnly opens the SQLite database file at path so that every
+// connection refuses writes.
+func OpenReadOnly(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", "file:"+path+"?mode=ro")
+}
+
+func (r ReadOnlyStore) Get(number int) (Parcel, error) {
+	return r.store.Get(number)
+}
+
+func (r ReadOnlyStore) GetByNumbers(numbers []int) (map[int]Parcel, error) {
+	return r.store.GetByNumbers(numbers)
+}
+
+func (r ReadOnlyStore) GetByClient(client int) ([]Parcel, error) {
+	return r.store.GetByClient(client)
+}
+
+func (r ReadOnlyStore) GetByClientPage(client, page, size int) (ParcelPage, error) {
+	return r.store.GetByClientPage(client, page, size)
+}
+
+func (r ReadOnlyStore) GetByStatus(status ParcelStatus) ([]Parcel, error) {
+	return r.store.GetByStatus(status)
+}
+
+func (r ReadOnlyStore) GetByStatusPage(status ParcelStatus, page, size int) (ParcelPage, error) {
+	return r.store.GetByStatusPage(status, page, size)
+}
+
+func (r ReadOnlyStore) GetByClientAndStatus(client int, status ParcelStatus) ([]Parcel, error) {
+	return r.store.GetByClientAndStatus(client, status)
+}
+
+func (r ReadOnlyStore) GetByDateRange(from, to time.Time) ([]Parcel, error) {
+	return r.store.GetByDateRange(from, to)
+}
+
+func (r ReadOnlyStore) GetStatusHistory(number int) ([]StatusEvent, error) {
+	return r.store.GetStatusHistory(number)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyStoreMethods(t *testing.T) {
+	typ := reflect.TypeOf(ReadOnlyStore{})
+	require.Positive(t, typ.NumMethod())
+
+	for i := 0; i < typ.NumMethod(); i++ {
+		name := typ.Method(i).Name
+		require.True(t, strings.HasPrefix(name, "Get"), "%s is not a query method", name)
+	}
+}
+
+func TestReadOnlyStore(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	parcel := getTestParcel()
+	id, err := NewParcelStore(db).Add(parcel)
+	require.NoError(t, err)
+	parcel.Number = id
+
+	store := NewReadOnlyStore(db)
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	requireParcelEqual(t, parcel, stored)
+
+	parcels, err := store.GetByClient(parcel.Client)
+	require.NoError(t, err)
+	require.Equal(t, []int{id}, parcelNumbers(parcels))
+
+	history, err := store.GetStatusHistory(id)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	path := filepath.Join(t.TempDir(), "tracker.db")
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	require.NoError(t, Migrate(db))
+	id, err := NewParcelStore(db).Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = OpenReadOnly(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = NewReadOnlyStore(db).Get(id)
+	require.NoError(t, err)
+
+	// The connection itself refuses writes.
+	_, err = NewParcelStore(db).Add(getTestParcel())
+	require.ErrorContains(t, err, "readonly")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// FindInvalidStatuses returns the parcels, deleted ones included, whose
+// status isn't one of the known statuses.
+func (s ParcelStore) FindInvalidStatuses() ([]Parcel, error) {
+	return s.FindInvalidStatusesContext(context.Background())
+}
+
+func (s ParcelStore) FindInvalidStatusesContext(ctx context.Context) (_ []Parcel, err error) {
+	defer s.observe("FindInvalidStatuses", time.Now(), &err)
+	ctx = s.withOp(ctx, "FindInvalidStatuses")
+
+	args := make([]any, len(parcelStatuses))
+	for i, status := range parcelStatuses {
+		args[i] = status
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE status NOT IN (` + placeholders(len(args)) + `)
+	ORDER BY number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// RepairStatuses rewrites each unknown status in mapping to the valid status
+// it maps to, in one transaction, and returns how many parcels changed. As
+// with SetStatus, delivered_at is set or cleared for the new status. Every
+// change is recorded in the parcel's status history and audit log. Mapping
+// from a valid status or to an invalid one fails before anything changes.
+func (s ParcelStore) RepairStatuses(mapping map[ParcelStatus]ParcelStatus) (fixed int, err error) {
+	return s.RepairStatusesContext(context.Background(), mapping)
+}
+
+func (s ParcelStore) RepairStatusesContext(ctx context.Context, mapping map[ParcelStatus]ParcelStatus) (fixed int, err error) {
+	defer s.observe("RepairStatuses", time.Now(), &err)
+	ctx = s.withOp(ctx, "RepairStatuses")
+
+	from := make([]ParcelStatus, 0, len(mapping))
+	for bad, good := range mapping {
+		if bad.IsValid() {
+			return 0, fmt.Errorf("%w: %q is a valid status", ErrInvalidStatus, bad)
+		}
+		if !good.IsValid() {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidStatus, good)
+		}
+		from = append(from, bad)
+	}
+	sort.Slice(from, func(i, j int) bool { return from[i] < from[j] })
+
+	err = s.inTx(ctx, func(tx querier) error {
+		fixed = 0
+		updatedAt := s.now()
+
+		for _, bad := range from {
+			query := `
+			SELECT number, delivered_at
+			FROM parcel
+			WHERE status = ?
+			ORDER BY number
+			`
+
+			rows, err := tx.QueryContext(ctx, query, bad)
+			if err != nil {
+				return err
+			}
+
+			var numbers []int
+			var delivered []sql.NullString
+			for rows.Next() {
+				var number int
+				var deliveredAt sql.NullString
+				if err := rows.Scan(&number, &deliveredAt); err != nil {
+					rows.Close()
+					return err
+				}
+				numbers = append(numbers, number)
+				delivered = append(delivered, deliveredAt)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			query = `
+			UPDATE parcel
+			SET status = ?, updated_at = ?, delivered_at = ?, version = version + 1
+			WHERE number = ?
+			`
+
+			good := mapping[bad]
+			for i, number := range numbers {
+				err := checkAffected(tx.ExecContext(ctx, query,
+					good, updatedAt, deliveredAt(good, delivered[i], updatedAt), number))
+				if err != nil {
+					return err
+				}
+
+				err = addStatusEvent(ctx, tx, number, StatusEvent{Status: good, ChangedAt: updatedAt})
+				if err != nil {
+					return err
+				}
+
+				err = addAuditEvent(ctx, tx, number, AuditEvent{
+					Field:     "status",
+					OldValue:  string(bad),
+					NewValue:  string(good),
+					ChangedAt: updatedAt,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			fixed += len(numbers)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return fixed, nil
+}
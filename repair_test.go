@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairStatuses(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	valid, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	createdAt := "2024-01-02T03:04:05Z"
+	insert := store.dialect.rebind(`
+	INSERT INTO parcel (client, status, address, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	`)
+	for _, status := range []string{"shipped", "SENT", "shipped"} {
+		_, err = db.Exec(insert, 7, status, "Main Street 1", createdAt, createdAt)
+		require.NoError(t, err)
+	}
+
+	invalid, err := store.FindInvalidStatuses()
+	require.NoError(t, err)
+	require.Len(t, invalid, 3)
+	require.Equal(t, []ParcelStatus{"shipped", "SENT", "shipped"},
+		[]ParcelStatus{invalid[0].Status, invalid[1].Status, invalid[2].Status})
+	require.NotContains(t, parcelNumbers(invalid), valid)
+
+	_, err = store.RepairStatuses(map[ParcelStatus]ParcelStatus{"shipped": "lost"})
+	require.ErrorIs(t, err, ErrInvalidStatus)
+
+	_, err = store.RepairStatuses(map[ParcelStatus]ParcelStatus{ParcelStatusSent: ParcelStatusDelivered})
+	require.ErrorIs(t, err, ErrInvalidStatus)
+
+	fixed, err := store.RepairStatuses(map[ParcelStatus]ParcelStatus{
+		"shipped": ParcelStatusSent,
+		"SENT":    ParcelStatusSent,
+		"missing": ParcelStatusReturned,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, fixed)
+
+	for _, p := range invalid {
+		stored, err := store.Get(p.Number)
+		require.NoError(t, err)
+		require.Equal(t, ParcelStatusSent, stored.Status)
+
+		log, err := store.GetAuditLog(p.Number)
+		require.NoError(t, err)
+		require.Len(t, log, 1)
+		require.Equal(t, "status", log[0].Field)
+		require.Equal(t, string(p.Status), log[0].OldValue)
+		require.Equal(t, string(ParcelStatusSent), log[0].NewValue)
+
+		history, err := store.GetStatusHistory(p.Number)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Equal(t, ParcelStatusSent, history[0].Status)
+		require.Equal(t, stored.UpdatedAt, history[0].ChangedAt)
+	}
+
+	invalid, err = store.FindInvalidStatuses()
+	require.NoError(t, err)
+	require.Empty(t, invalid)
+
+	// delivered_at follows the repaired status.
+	insert = store.dialect.rebind(`
+	INSERT INTO parcel (client, status, address, created_at, updated_at, delivered_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	_, err = db.Exec(insert, 7, "done", "Main Street 1", createdAt, createdAt, nil)
+	require.NoError(t, err)
+	_, err = db.Exec(insert, 7, "back", "Main Street 1", createdAt, createdAt, createdAt)
+	require.NoError(t, err)
+
+	invalid, err = store.FindInvalidStatuses()
+	require.NoError(t, err)
+	require.Len(t, invalid, 2)
+
+	_, err = store.RepairStatuses(map[ParcelStatus]ParcelStatus{
+		"done": ParcelStatusDelivered,
+		"back": ParcelStatusReturned,
+	})
+	require.NoError(t, err)
+
+	done, err := store.Get(invalid[0].Number)
+	require.NoError(t, err)
+	require.NotNil(t, done.DeliveredAt)
+
+	back, err := store.Get(invalid[1].Number)
+	require.NoError(t, err)
+	require.Nil(t, back.DeliveredAt)
+}
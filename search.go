@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// searchSchema indexes parcel addresses in an FTS5 table that triggers keep
+// in sync with parcel.
+const searchSchema = `
+	CREATE VIRTUAL TABLE parcel_fts USING fts5(address, content='parcel', content_rowid='number');
+
+	CREATE TRIGGER parcel_fts_insert AFTER INSERT ON parcel BEGIN
+		INSERT INTO parcel_fts (rowid, address) VALUES (new.number, new.address);
+	END;
+
+	CREATE TRIGGER parcel_fts_delete AFTER DELETE ON parcel BEGIN
+		INSERT INTO parcel_fts (parcel_fts, rowid, address) VALUES ('delete', old.number, old.address);
+	END;
+
+	CREATE TRIGGER parcel_fts_update AFTER UPDATE OF address ON parcel BEGIN
+		INSERT INTO parcel_fts (parcel_fts, rowid, address) VALUES ('delete', old.number, old.address);
+		INSERT INTO parcel_fts (rowid, address) VALUES (new.number, new.address);
+	END;
+
+	INSERT INTO parcel_fts (parcel_fts) VALUES ('rebuild');`
+
+// schemaQuerier is satisfied by both *sql.DB and *sql.Tx.
+type schemaQuerier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// initSearch creates the address search index if SQLite was built with
+// FTS5. Without it the index is skipped and SearchAddress falls back to LIKE.
+func initSearch(db schemaQuerier, table string) error {
+	exists, err := hasSearchIndex(db, table)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(renameTables(searchSchema, table))
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		return nil
+	}
+
+	return err
+}
+
+func hasSearchIndex(db schemaQuerier, table string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table+"_fts").Scan(&n)
+
+	return n > 0, err
+}
+
+// SearchAddress finds parcels whose address contains every word of query, in
+// any order. With the FTS5 index the best matches come first; otherwise
+// results are ordered by number.
+func (s ParcelStore) SearchAddress(query string) ([]Parcel, error) {
+	return s.SearchAddressContext(context.Background(), query)
+}
+
+func (s ParcelStore) SearchAddressContext(ctx context.Context, query string) (_ []Parcel, err error) {
+	defer s.observe("SearchAddress", time.Now(), &err)
+	ctx = s.withOp(ctx, "SearchAddress")
+
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return []Parcel{}, nil
+	}
+
+	if s.fts {
+		return s.searchFTS(ctx, words)
+	}
+
+	return s.searchLike(ctx, words)
+}
+
+func (s ParcelStore) searchFTS(ctx context.Context, words []string) ([]Parcel, error) {
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	JOIN (SELECT rowid, rank FROM parcel_fts WHERE parcel_fts MATCH ?) AS f ON f.rowid = parcel.number
+	WHERE deleted_at IS NULL
+	ORDER BY f.rank, number
+	`
+
+	// Quoting each word keeps FTS5 query syntax in user input from being
+	// interpreted; adjacent strings are ANDed.
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+
+	rows, err := s.conn().QueryContext(ctx, query, pii(strings.Join(terms, " ")))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+func (s ParcelStore) searchLike(ctx context.Context, words []string) ([]Parcel, error) {
+	var where []string
+	var args []any
+
+	for _, w := range words {
+		where = append(where, `LOWER(address) LIKE LOWER(?) ESCAPE '!'`)
+		args = append(args, pii("%"+escapeLike(w)+"%"))
+	}
+
+	query := `
+	SELECT ` + parcelColumns + `
+	FROM parcel
+	WHERE ` + strings.Join(where, " AND ") + ` AND deleted_at IS NULL
+	ORDER BY number
+	`
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchAddress(t *testing.T) {
+	skipUnlessSQLite(t)
+
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	require.True(t, store.fts)
+
+	addresses := []string{
+		"Baker Road, near the old Street market by the river",
+		"12 Baker Street",
+		"Abbey Road",
+		"Street of Bakers",
+	}
+	numbers := make([]int, len(addresses))
+	for i, address := range addresses {
+		p := getTestParcel()
+		p.Address = address
+		numbers[i], err = store.Add(p)
+		require.NoError(t, err)
+	}
+
+	// The short address that matches both words ranks above the long one.
+	for _, query := range []string{"baker street", "Street  BAKER"} {
+		found, err := store.SearchAddress(query)
+		require.NoError(t, err)
+		require.Equal(t, []int{numbers[1], numbers[0]}, parcelNumbers(found), query)
+	}
+
+	// Query syntax characters are treated as plain text.
+	found, err := store.SearchAddress(`"abbey OR`)
+	require.NoError(t, err)
+	require.Empty(t, found)
+
+	// The index follows address changes and deletes.
+	require.NoError(t, store.SetAddress(numbers[2], "Baker Street corner"))
+	require.NoError(t, store.Delete(numbers[1]))
+
+	found, err = store.SearchAddress("baker street")
+	require.NoError(t, err)
+	require.Equal(t, []int{numbers[2], numbers[0]}, parcelNumbers(found))
+
+	found, err = store.SearchAddress("  ")
+	require.NoError(t, err)
+	require.Empty(t, found)
+}
+
+func TestSearchAddressFallback(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	store.fts = false
+
+	var numbers []int
+	for _, address := range []string{"Baker Road, old Street market", "12 Baker Street", "Abbey Road", "Псков, ул. Колотушкина"} {
+		p := getTestParcel()
+		p.Address = address
+		id, err := store.Add(p)
+		require.NoError(t, err)
+		numbers = append(numbers, id)
+	}
+
+	found, err := store.SearchAddress("street baker")
+	require.NoError(t, err)
+	require.Equal(t, numbers[:2], parcelNumbers(found))
+
+	found, err = store.SearchAddress("Колотушкина Псков")
+	require.NoError(t, err)
+	require.Equal(t, numbers[3:], parcelNumbers(found))
+}
+
+func parcelNumbers(parcels []Parcel) []int {
+	numbers := make([]int, len(parcels))
+	for i, p := range parcels {
+		numbers[i] = p.Number
+	}
+
+	return numbers
+}
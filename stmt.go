@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// maxCachedStmts bounds the statements a store keeps prepared. Queries with
+// an IN list of varying length are distinct, so the cache could otherwise
+// grow without limit.
+const maxCachedStmts = 64
+
+// stmtCache prepares each distinct query once per store and reuses the
+// statement for later calls.
+type stmtCache struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	stmts  map[string]*sql.Stmt
+	closed bool
+}
+
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{db: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+// prepare returns the cached statement for query, preparing it on first use.
+// It returns nil once the cache is closed or full.
+func (c *stmtCache) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, nil
+	}
+
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	if len(c.stmts) >= maxCachedStmts {
+		return nil, nil
+	}
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+func (c *stmtCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.stmts, query)
+	}
+	c.closed = true
+
+	return firstErr
+}
+
+// cachedQuerier runs queries through the cache, on tx when it is set. When a
+// statement can't be prepared the query runs ad hoc, which reports the error.
+type cachedQuerier struct {
+	c  *stmtCache
+	db *sql.DB
+	tx *sql.Tx
+}
+
+func (q cachedQuerier) base() querier {
+	if q.tx != nil {
+		return q.tx
+	}
+
+	return q.db
+}
+
+// stmt returns the cached statement for query, or nil to run it ad hoc.
+// Inside a transaction queries always run ad hoc: preparing on the pool
+// needs a second connection while the transaction holds one, which never
+// comes with a pool of one.
+func (q cachedQuerier) stmt(ctx context.Context, query string) *sql.Stmt {
+	if q.tx != nil {
+		return nil
+	}
+
+	stmt, err := q.c.prepare(ctx, query)
+	if err != nil {
+		return nil
+	}
+
+	return stmt
+}
+
+func (q cachedQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if stmt := q.stmt(ctx, query); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+
+	return q.base().ExecContext(ctx, query, args...)
+}
+
+func (q cachedQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if stmt := q.stmt(ctx, query); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+
+	return q.base().QueryContext(ctx, query, args...)
+}
+
+func (q cachedQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if stmt := q.stmt(ctx, query); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+
+	return q.base().QueryRowContext(ctx, query, args...)
+}
+
+// Close releases the store's prepared statements. For a store given its
+// database by NewParcelStore, the store keeps working, running queries ad
+// hoc, and the database stays open. A store from OpenParcelStore closes its
+// database as well. Close may be called more than once.
+func (s ParcelStore) Close() error {
+	var err error
+	if s.stmts != nil {
+		err = s.stmts.close()
+	}
+
+	if s.closeDB != nil {
+		if closeErr := s.closeDB(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// querier returns the connection queries should go through: tx when set,
+// otherwise the database, using cached statements when the store has them.
+func (s ParcelStore) querier(tx *sql.Tx) querier {
+	var q querier
+
+	switch {
+	case s.stmts != nil:
+		q = cachedQuerier{c: s.stmts, db: s.db, tx: tx}
+	case tx != nil:
+		q = tx
+	default:
+		q = s.db
+	}
+
+	if s.logger != nil {
+		q = loggingQuerier{q: q, logger: s.logger}
+	}
+
+	return s.bind(q)
+}
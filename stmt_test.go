@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreClose(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	_, err = store.Get(id)
+	require.NoError(t, err)
+	require.NotEmpty(t, store.stmts.stmts)
+
+	require.NoError(t, store.Close())
+	require.Empty(t, store.stmts.stmts)
+
+	// The database is still open and the store falls back to ad hoc queries.
+	require.NoError(t, db.Ping())
+
+	_, err = store.Get(id)
+	require.NoError(t, err)
+	require.Empty(t, store.stmts.stmts)
+
+	require.NoError(t, store.Close())
+	require.NoError(t, db.Ping())
+}
+
+func TestOpenParcelStoreClose(t *testing.T) {
+	store, err := OpenParcelStore("sqlite", filepath.Join(t.TempDir(), "tracker.db"))
+	require.NoError(t, err)
+
+	_, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Close())
+	require.Error(t, store.db.Ping())
+
+	require.NoError(t, store.Close())
+}
+
+func TestStmtCacheBounded(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	defer store.Close()
+
+	for n := 1; n <= maxCachedStmts+10; n++ {
+		_, err := store.GetByNumbers(make([]int, n))
+		require.NoError(t, err)
+	}
+	require.Len(t, store.stmts.stmts, maxCachedStmts)
+
+	// Queries past the limit still run, ad hoc.
+	_, err = store.GetByClients([]int{1, 2, 3})
+	require.NoError(t, err)
+}
+
+func BenchmarkGet(b *testing.B) {
+	db, err := openTestDB(b)
+	require.NoError(b, err)
+	defer db.Close()
+
+	prepared := NewParcelStore(db)
+	defer prepared.Close()
+
+	adHoc := prepared
+	adHoc.stmts = nil
+
+	id, err := prepared.Add(getTestParcel())
+	require.NoError(b, err)
+
+	for _, bench := range []struct {
+		name  string
+		store ParcelStore
+	}{{"prepared", prepared}, {"ad hoc", adHoc}} {
+		store := bench.store
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := store.Get(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const defaultTable = "parcel"
+
+var tableNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// tableRefPattern matches the parcel table and the tables, indexes and
+// triggers named after it in queries written for the default table.
+var tableRefPattern = regexp.MustCompile(
+	`\bparcel(_status_history|_audit|_idempotency_keys|_meta|_archive|_fts_insert|_fts_delete|_fts_update|_fts|_client_idx|_status_idx|_external_code_idx)?\b`)
+
+func validateTableName(table string) error {
+	if !tableNamePattern.MatchString(table) {
+		return fmt.Errorf("%w: %q", ErrInvalidTableName, table)
+	}
+
+	return nil
+}
+
+// renameTables rewrites a query written for the default table to use table
+// and the tables named after it.
+func renameTables(query, table string) string {
+	if table == defaultTable {
+		return query
+	}
+
+	return tableRefPattern.ReplaceAllString(query, table+"${1}")
+}
+
+// WithTable makes the store use table instead of parcel, along with the
+// history, archive, search and other tables named after it. MigrateTable
+// creates them. The name must be a lowercase identifier; NewParcelStore
+// panics otherwise.
+func WithTable(table string) Option {
+	return func(s *ParcelStore) {
+		s.table = table
+	}
+}
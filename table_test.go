@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTable(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	stores := map[string]ParcelStore{}
+	for _, table := range []string{"parcel_eu", "parcel_us"} {
+		for _, suffix := range []string{"_fts", "_archive", "_audit", "_idempotency_keys", "_meta", "_status_history", ""} {
+			_, err := db.Exec("DROP TABLE IF EXISTS " + table + suffix)
+			require.NoError(t, err)
+		}
+		require.NoError(t, MigrateTable(db, table))
+
+		stores[table] = NewParcelStore(db, WithTable(table))
+	}
+
+	eu := getTestParcel()
+	eu.Address = "Berlin"
+	eu.Number, err = stores["parcel_eu"].Add(eu)
+	require.NoError(t, err)
+
+	us := getTestParcel()
+	us.Address = "Boston"
+	us.Number, err = stores["parcel_us"].Add(us)
+	require.NoError(t, err)
+
+	require.NoError(t, stores["parcel_us"].SetStatus(us.Number, ParcelStatusSent))
+	us.Status = ParcelStatusSent
+
+	parcels, err := stores["parcel_eu"].GetByClient(eu.Client)
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{eu}, parcels)
+
+	parcels, err = stores["parcel_us"].GetByClient(us.Client)
+	require.NoError(t, err)
+	requireParcelsEqual(t, []Parcel{us}, parcels)
+
+	history, err := stores["parcel_eu"].GetStatusHistory(eu.Number)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+
+	// The default table is untouched.
+	parcels, err = NewParcelStore(db).GetByClient(eu.Client)
+	require.NoError(t, err)
+	require.Empty(t, parcels)
+}
+
+func TestInvalidTableName(t *testing.T) {
+	db, err := openTestDB(t)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, table := range []string{"", "Parcel", "parcel; DROP TABLE parcel", "1parcel", "parcel-eu"} {
+		require.ErrorIs(t, MigrateTable(db, table), ErrInvalidTableName, table)
+		require.Panics(t, func() { NewParcelStore(db, WithTable(table)) }, table)
+
+		_, err := OpenParcelStore("sqlite", ":memory:", WithTable(table))
+		require.ErrorIs(t, err, ErrInvalidTableName, table)
+	}
+}
+
+func TestRenameTables(t *testing.T) {
+	query := "SELECT number FROM parcel JOIN parcel_fts ON parcel_fts.rowid = parcel.number WHERE parcel_number = ?"
+
+	require.Equal(t,
+		"SELECT number FROM parcel_eu JOIN parcel_eu_fts ON parcel_eu_fts.rowid = parcel_eu.number WHERE parcel_number = ?",
+		renameTables(query, "parcel_eu"))
+	require.Equal(t, query, renameTables(query, defaultTable))
+}